@@ -0,0 +1,175 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/camopy/rss_everything/bot/feeder"
+	"github.com/camopy/rss_everything/bot/models"
+	"github.com/camopy/rss_everything/util/psub"
+	"github.com/camopy/rss_everything/zaplog"
+)
+
+const maxAttachmentSize = 10 << 20
+
+// dispatchCommand handles the bot-level commands and routes everything else
+// to the feed owning the command.
+func dispatchCommand(
+	ctx context.Context,
+	logger *zaplog.Logger,
+	publisher psub.Publisher[[]models.Content],
+	feeds []*feeder.Feed,
+	cmd models.Command,
+) {
+	reply := func(text string, file *models.File) {
+		_ = publisher.SendData(ctx, []models.Content{
+			{
+				ThreadId: cmd.ThreadId,
+				Text:     text,
+				File:     file,
+			},
+		})
+	}
+
+	switch cmd.Name {
+	case "/help":
+		reply(feedsHelp(feeds), nil)
+		return
+	case "/backup":
+		file, err := backupFeeds(ctx, feeds)
+		if err != nil {
+			logger.Error("backup failed", zap.Error(err))
+			reply(fmt.Sprintf("backup failed: %v", err), nil)
+			return
+		}
+		reply("backup of all subscriptions", file)
+		return
+	case "/restore":
+		msg, err := restoreFeeds(ctx, feeds, cmd.Attachment)
+		if err != nil {
+			logger.Error("restore failed", zap.Error(err))
+			reply(fmt.Sprintf("restore failed: %v", err), nil)
+			return
+		}
+		reply(msg, nil)
+		return
+	}
+
+	for _, feed := range feeds {
+		if !feed.Command().Matches(cmd.Name) {
+			continue
+		}
+		if err := feed.HandleCommand(ctx, cmd); err != nil {
+			logger.Error("command failed", zap.String("feed", feed.Name()), zap.Error(err))
+		}
+		return
+	}
+}
+
+// redactCommand hides the secret arguments of the commands of all feeds in
+// text, so messages can be logged.
+func redactCommand(feeds []*feeder.Feed, text string) string {
+	var secrets []string
+	for _, feed := range feeds {
+		secrets = append(secrets, feed.Command().Secrets()...)
+	}
+	return models.Redact(text, secrets)
+}
+
+func feedsHelp(feeds []*feeder.Feed) string {
+	help := make([]string, 0, len(feeds)+1)
+	for _, feed := range feeds {
+		help = append(help, feed.Command().Help())
+	}
+	help = append(help, `/backup - reply with a JSON backup of all subscriptions
+/restore - restore subscriptions from an attached JSON backup
+
+Arguments can also be given as key=value, e.g. interval=120.
+Quote values with spaces: title="My Feed".
+Use /<command> help for a single command.`)
+	return strings.Join(help, "\n")
+}
+
+// backup maps each feed table name to its subscriptions.
+type backup map[string][]models.Subscription
+
+func backupFeeds(ctx context.Context, feeds []*feeder.Feed) (*models.File, error) {
+	b := make(backup, len(feeds))
+	for _, feed := range feeds {
+		subs, err := feed.Backup(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", feed.Name(), err)
+		}
+		b[feed.TableName()] = subs
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &models.File{
+		Name: fmt.Sprintf("backup-%s.json", time.Now().UTC().Format("2006-01-02")),
+		Data: data,
+	}, nil
+}
+
+func restoreFeeds(ctx context.Context, feeds []*feeder.Feed, file *models.File) (string, error) {
+	if file == nil {
+		return "", fmt.Errorf("attach a backup file")
+	}
+	var b backup
+	if err := json.Unmarshal(file.Data, &b); err != nil {
+		return "", fmt.Errorf("invalid backup file: %w", err)
+	}
+
+	var lines []string
+	for _, feed := range feeds {
+		subs, ok := b[feed.TableName()]
+		if !ok {
+			continue
+		}
+		added, skipped, err := feed.Restore(ctx, subs)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", feed.Name(), err)
+		}
+		lines = append(lines, fmt.Sprintf("%s: restored %d, skipped %d", feed.Name(), added, len(skipped)))
+		lines = append(lines, skipped...)
+	}
+	if len(lines) == 0 {
+		return "nothing to restore", nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func downloadFile(ctx context.Context, name string, url string) (*models.File, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download %s: unexpected status %d", name, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAttachmentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxAttachmentSize {
+		return nil, fmt.Errorf("download %s: file is larger than %d bytes", name, maxAttachmentSize)
+	}
+	return &models.File{
+		Name: name,
+		Data: data,
+	}, nil
+}
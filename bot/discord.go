@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/avast/retry-go/v4"
 	"github.com/bwmarrin/discordgo"
@@ -23,15 +25,34 @@ import (
 	"github.com/camopy/rss_everything/db"
 	"github.com/camopy/rss_everything/util/psub"
 	"github.com/camopy/rss_everything/util/run"
+	"github.com/camopy/rss_everything/websub"
 	"github.com/camopy/rss_everything/zaplog"
 )
 
+const (
+	// maxEmbeds is the Discord limit of embeds in a message.
+	maxEmbeds = 10
+	// maxMessageLength is the Discord limit of characters in a message.
+	maxMessageLength = 2000
+)
+
 type DiscordConfig struct {
 	DiscordApiKey  string
 	RedditClientId string
 	RedditApiKey   string
 	RedditUsername string
 	RedditPassword string
+	// WebSubCallbackUrl enables WebSub push for RSS feeds when set.
+	WebSubCallbackUrl string
+	WebSubAddr        string
+	// RssSecretKey encrypts the credentials of RSS subscriptions.
+	RssSecretKey string
+	// ScrapperDefinitions is the path of a JSON file with scraper
+	// definitions, optional.
+	ScrapperDefinitions string
+	// ScrapperClient sets the rate limits, robots.txt policy and proxies of
+	// the scrapper.
+	ScrapperClient scrapper.ClientConfig
 }
 
 type Discord struct {
@@ -122,12 +143,11 @@ func (b *Discord) handleContentUpdates(ctx context.Context) error {
 		return errors.As(err, &rateLimitError)
 	}
 	return psub.ProcessWithContext(ctx, b.contentSubscriber.Subscribe(ctx), func(ctx context.Context, contents []models.Content) error {
-		for _, c := range contents {
+		for _, c := range splitContents(contents) {
 			attempt := 0
 			err := retry.Do(
 				func() error {
-					_, err := b.client.ChannelMessageSend(strconv.Itoa(c.ThreadId), c.Text)
-					return err
+					return b.send(ctx, c)
 				},
 				retry.RetryIf(isTooManyRequestsError),
 				retry.LastErrorOnly(true),
@@ -153,12 +173,93 @@ func (b *Discord) handleContentUpdates(ctx context.Context) error {
 	})
 }
 
+// splitContents splits the contents with a text too long for a Discord
+// message into several, so each part is retried on its own. The first part
+// keeps the files, media, key and reply of the content.
+func splitContents(contents []models.Content) []models.Content {
+	var split []models.Content
+	for _, c := range contents {
+		parts := splitText(c.Text, maxMessageLength)
+		c.Text = parts[0]
+		split = append(split, c)
+		for _, text := range parts[1:] {
+			split = append(split, models.Content{ThreadId: c.ThreadId, Text: text})
+		}
+	}
+	return split
+}
+
+// splitText splits text into parts of at most n characters, breaking at the
+// last line break or space of a part when there is one.
+func splitText(text string, n int) []string {
+	var parts []string
+	for utf8.RuneCountInString(text) > n {
+		cut := len(string([]rune(text)[:n]))
+		switch {
+		case text[cut] == '\n' || text[cut] == ' ':
+		case strings.LastIndex(text[:cut], "\n") > 0:
+			cut = strings.LastIndex(text[:cut], "\n")
+		case strings.LastIndex(text[:cut], " ") > 0:
+			cut = strings.LastIndex(text[:cut], " ")
+		}
+		parts = append(parts, strings.TrimRight(text[:cut], " \n"))
+		text = strings.TrimLeft(text[cut:], " \n")
+	}
+	return append(parts, text)
+}
+
+// send delivers c to its channel, attaching its file and uploaded media and
+// embedding media that is only linked. It replies to the message c replies
+// to when known, and records the message it was sent as when c has a key.
+func (b *Discord) send(ctx context.Context, c models.Content) error {
+	channelId := strconv.Itoa(c.ThreadId)
+	msg := &discordgo.MessageSend{Content: c.Text}
+	if c.File != nil {
+		msg.Files = append(msg.Files, &discordgo.File{
+			Name:   c.File.Name,
+			Reader: bytes.NewReader(c.File.Data),
+		})
+	}
+	for _, m := range c.Media {
+		switch {
+		case m.File != nil:
+			msg.Files = append(msg.Files, &discordgo.File{
+				Name:   m.File.Name,
+				Reader: bytes.NewReader(m.File.Data),
+			})
+		case m.Type == models.MediaPhoto && m.Url != "" && len(msg.Embeds) < maxEmbeds:
+			msg.Embeds = append(msg.Embeds, &discordgo.MessageEmbed{
+				Image: &discordgo.MessageEmbedImage{URL: m.Url},
+			})
+		}
+	}
+
+	messages := sentMessages{db: b.db}
+	replyTo, err := messages.replyTo(ctx, c)
+	if err != nil {
+		b.logger.Warn("failed to read the message to reply to", zap.String("key", c.ReplyTo), zap.Error(err))
+	}
+	if replyTo != "" {
+		failIfNotExists := false
+		msg.Reference = &discordgo.MessageReference{MessageID: replyTo, ChannelID: channelId, FailIfNotExists: &failIfNotExists}
+	}
+
+	sent, err := b.client.ChannelMessageSendComplex(channelId, msg)
+	if err != nil {
+		return err
+	}
+	if err := messages.save(ctx, c, sent.ID); err != nil {
+		b.logger.Warn("failed to save sent message", zap.String("key", c.Key), zap.Error(err))
+	}
+	return nil
+}
+
 func (b *Discord) handleMessages(ctx context.Context) error {
 	return psub.ProcessWithContext(ctx, b.discordSubscriber.Subscribe(ctx), func(ctx context.Context, update *discordgo.MessageCreate) error {
 		b.logger.Info(
 			"message received",
 			zap.String("threadId", update.Message.ChannelID),
-			zap.String("msg", update.Message.Content),
+			zap.String("msg", redactCommand(b.feeds(), update.Message.Content)),
 		)
 
 		//if !b.isValidChatId(update.Message.Chat.ID) {
@@ -175,45 +276,49 @@ func (b *Discord) handleMessages(ctx context.Context) error {
 //}
 
 func (b *Discord) handleCommand(ctx context.Context, update *discordgo.MessageCreate) {
-	b.logger.Info("command received", zap.String("cmd", update.Content))
+	name, text, ok := models.SplitCommand(update.Message.Content)
+	if !ok {
+		return
+	}
+	b.logger.Info("command received", zap.String("cmd", redactCommand(b.feeds(), update.Content)))
 	threadId, err := strconv.Atoi(update.Message.ChannelID)
 	if err != nil {
 		b.logger.Error("failed to parse thread id", zap.Error(err))
 		return
 	}
-	name := strings.Split(update.Message.Content, " ")[0]
 	cmd := models.Command{
 		Name:     name,
 		ThreadId: threadId,
-		Text:     update.Message.Content[len(name)+1:],
+		Text:     text,
 	}
 
-	switch cmd.Name {
-	case "/scrapper":
-		err := b.scrapper.HandleCommand(ctx, cmd)
-		if err != nil {
-			b.logger.Error("scrapper command failed", zap.Error(err))
-		}
-	case "/reddit":
-		err := b.reddit.HandleCommand(ctx, cmd)
+	if len(update.Message.Attachments) > 0 {
+		a := update.Message.Attachments[0]
+		attachment, err := downloadFile(ctx, a.Filename, a.URL)
 		if err != nil {
-			b.logger.Error("reddit command failed", zap.Error(err))
-		}
-	case "/rss":
-		err := b.rss.HandleCommand(ctx, cmd)
-		if err != nil {
-			b.logger.Error("rss command failed", zap.Error(err))
-		}
-	case "/hn":
-		err := b.hackerNews.HandleCommand(ctx, cmd)
-		if err != nil {
-			b.logger.Error("hacker news command failed", zap.Error(err))
+			b.logger.Error("failed to download attachment", zap.String("file", a.Filename), zap.Error(err))
 		}
+		cmd.Attachment = attachment
 	}
+
+	dispatchCommand(ctx, b.logger, b.contentPublisher, b.feeds(), cmd)
+}
+
+func (b *Discord) feeds() []*feeder.Feed {
+	return []*feeder.Feed{b.hackerNews, b.reddit, b.rss, b.scrapper}
 }
 
 func (b *Discord) initFeeds(ctx run.Context, cfg DiscordConfig) {
 	logger := b.logger.Named("feeds")
+
+	var push *websub.Subscriber
+	if cfg.WebSubCallbackUrl != "" {
+		push = websub.New(logger.Named("websub"), b.db, websub.Config{
+			CallbackUrl: cfg.WebSubCallbackUrl,
+			Addr:        cfg.WebSubAddr,
+		})
+		ctx.Start(push)
+	}
 	b.hackerNews = feeder.New(
 		logger,
 		b.contentPublisher,
@@ -245,6 +350,9 @@ func (b *Discord) initFeeds(ctx run.Context, cfg DiscordConfig) {
 		rss.New(
 			logger.Named("rss"),
 			b.db,
+			b.contentPublisher,
+			push,
+			cfg.RssSecretKey,
 		),
 	)
 
@@ -255,6 +363,8 @@ func (b *Discord) initFeeds(ctx run.Context, cfg DiscordConfig) {
 		scrapper.New(
 			logger.Named("scrapper"),
 			b.db,
+			scrapper.WithDefinitionsFile(cfg.ScrapperDefinitions),
+			scrapper.WithClientConfig(cfg.ScrapperClient),
 		),
 	)
 
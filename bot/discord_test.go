@@ -0,0 +1,35 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+func TestSplitText(t *testing.T) {
+	assert.Equal(t, []string{"short"}, splitText("short", 10))
+	assert.Equal(t, []string{"first line", "second", "line"}, splitText("first line\nsecond line", 10))
+	assert.Equal(t, []string{"ééééé", "ééé"}, splitText("éééééééé", 5))
+
+	long := strings.TrimSpace(strings.Repeat("word ", 1000))
+	for _, part := range splitText(long, maxMessageLength) {
+		assert.LessOrEqual(t, len([]rune(part)), maxMessageLength)
+		assert.True(t, strings.HasSuffix(part, "word"))
+	}
+}
+
+func TestSplitContents(t *testing.T) {
+	file := &models.File{Name: "a.txt"}
+	contents := splitContents([]models.Content{
+		{ThreadId: 1, Text: strings.Repeat("a", maxMessageLength) + " tail", File: file, Key: "k", ReplyTo: "r"},
+		{ThreadId: 2, Text: "short"},
+	})
+	assert.Equal(t, []models.Content{
+		{ThreadId: 1, Text: strings.Repeat("a", maxMessageLength), File: file, Key: "k", ReplyTo: "r"},
+		{ThreadId: 1, Text: "tail"},
+		{ThreadId: 2, Text: "short"},
+	}, contents)
+}
@@ -24,7 +24,32 @@ type Feeder interface {
 	TableName() string
 
 	Fetch(ctx context.Context, sub *models.Subscription) ([]models.Content, error)
+	// Command describes the actions and arguments accepted by ParseCommand.
+	Command() models.CommandSpec
 	ParseCommand(cmd models.Command) (models.Commander, error)
+	// Validate checks that a new subscription can be fetched without
+	// persisting anything, so a bad URL or name is rejected on add.
+	Validate(ctx context.Context, sub *models.Subscription) error
+}
+
+// Importer is implemented by feeders that can convert subscriptions from and
+// to an external format, such as OPML for RSS.
+type Importer interface {
+	Import(data []byte) ([]models.Subscription, error)
+	Export(subs []models.Subscription) (*models.File, error)
+}
+
+// CommandHandler is implemented by feeders with actions of their own, such
+// as managing the scraper definitions. The returned text is sent as reply.
+type CommandHandler interface {
+	HandleAction(ctx context.Context, c models.Commander, attachment *models.File) (string, error)
+}
+
+// Remover is implemented by feeders holding resources of a subscription
+// beyond its polling, such as a WebSub lease. Remove is only called when the
+// subscription is removed, not when the bot stops.
+type Remover interface {
+	Remove(ctx context.Context, sub *models.Subscription) error
 }
 
 type Feed struct {
@@ -51,6 +76,14 @@ func (h *Feed) Name() string {
 	return h.feeder.Name()
 }
 
+func (h *Feed) TableName() string {
+	return h.feeder.TableName()
+}
+
+func (h *Feed) Command() models.CommandSpec {
+	return h.feeder.Command()
+}
+
 func (h *Feed) Start(ctx run.Context) error {
 	h.logger.Info("starting", zap.String("feed", h.feeder.Name()))
 	subs, err := h.getSubscriptions(ctx)
@@ -68,19 +101,154 @@ func (h *Feed) Start(ctx run.Context) error {
 }
 
 func (h *Feed) HandleCommand(ctx context.Context, cmd models.Command) error {
+	err := h.handleCommand(ctx, cmd)
+	if err != nil {
+		_ = h.reply(ctx, cmd.ThreadId, err.Error())
+	}
+	return err
+}
+
+func (h *Feed) handleCommand(ctx context.Context, cmd models.Command) error {
+	if spec := h.feeder.Command(); spec.IsHelp(cmd.Text) {
+		return h.reply(ctx, cmd.ThreadId, spec.Help())
+	}
 	c, err := h.feeder.ParseCommand(cmd)
 	if err != nil {
 		return err
 	}
 	switch c.Action() {
 	case "add":
-		err = h.add(ctx, c)
+		return h.add(ctx, c)
 	case "list":
-		err = h.list(ctx, c)
+		return h.list(ctx, c)
 	case "remove":
-		err = h.remove(ctx, c)
+		return h.remove(ctx, c)
+	case "import":
+		return h.importSubscriptions(ctx, c, cmd.Attachment)
+	case "export":
+		return h.exportSubscriptions(ctx, c)
 	}
-	return err
+	if handler, ok := h.feeder.(CommandHandler); ok {
+		reply, err := handler.HandleAction(ctx, c, cmd.Attachment)
+		if err != nil {
+			return err
+		}
+		return h.reply(ctx, c.ThreadId(), reply)
+	}
+	return fmt.Errorf("%s: unknown action %q", h.feeder.Name(), c.Action())
+}
+
+func (h *Feed) importSubscriptions(ctx context.Context, c models.Commander, file *models.File) error {
+	importer, ok := h.feeder.(Importer)
+	if !ok {
+		return fmt.Errorf("%s: import is not supported", h.feeder.Name())
+	}
+	if file == nil {
+		return fmt.Errorf("%s: attach a file to import", h.feeder.Name())
+	}
+
+	subs, err := importer.Import(file.Data)
+	if err != nil {
+		return fmt.Errorf("%s: invalid import file: %w", h.feeder.Name(), err)
+	}
+	for i := range subs {
+		subs[i].ThreadId = c.ThreadId()
+		subs[i].Interval = ge.DefaultIfZero(c.Interval(), defaultFetchInterval)
+	}
+
+	added, skipped, err := h.Restore(ctx, subs)
+	if err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("%s: imported %d subscriptions", h.feeder.Name(), added)
+	if len(skipped) > 0 {
+		msg += fmt.Sprintf(", skipped %d:\n%s", len(skipped), strings.Join(skipped, "\n"))
+	}
+	return h.reply(ctx, c.ThreadId(), msg)
+}
+
+func (h *Feed) exportSubscriptions(ctx context.Context, c models.Commander) error {
+	importer, ok := h.feeder.(Importer)
+	if !ok {
+		return fmt.Errorf("%s: export is not supported", h.feeder.Name())
+	}
+
+	subs, err := h.getSubscriptions(ctx)
+	if err != nil && !h.db.IsErrNotFound(err) {
+		return err
+	}
+	file, err := importer.Export(subs)
+	if err != nil {
+		return err
+	}
+	return h.contentPublisher.SendData(ctx, []models.Content{
+		{
+			ThreadId: c.ThreadId(),
+			Text:     fmt.Sprintf("%s: %d subscriptions", h.feeder.Name(), len(subs)),
+			File:     file,
+		},
+	})
+}
+
+// Backup returns every stored subscription of the feed.
+func (h *Feed) Backup(ctx context.Context) ([]models.Subscription, error) {
+	subs, err := h.getSubscriptions(ctx)
+	if err != nil && !h.db.IsErrNotFound(err) {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// Restore validates, saves and starts polling the given subscriptions, as
+// add does. Subscriptions whose name is already taken or that fail the
+// checks of add are left untouched and returned as skipped, with the reason.
+func (h *Feed) Restore(ctx context.Context, subs []models.Subscription) (added int, skipped []string, err error) {
+	for i := range subs {
+		sub := subs[i]
+		if err := h.checkRestored(ctx, &sub); err != nil {
+			h.logger.Warn("subscription not restored", zap.String("feed", h.feeder.Name()), zap.String("name", sub.Name), zap.Error(err))
+			skipped = append(skipped, fmt.Sprintf("%s: %v", ge.DefaultIfZero(sub.Name, "unnamed"), err))
+			continue
+		}
+		if err := h.saveSubscription(ctx, &sub); err != nil {
+			return added, skipped, err
+		}
+		h.pollFeed(ctx, &sub)
+		added++
+	}
+	h.logger.Info(
+		"subscriptions restored",
+		zap.String("feed", h.feeder.Name()),
+		zap.Int("added", added),
+		zap.Int("skipped", len(skipped)),
+	)
+	return added, skipped, nil
+}
+
+// checkRestored applies the checks of add to a restored subscription, which
+// may come from a file: a free name, the minimum interval and the
+// validation of the feeder.
+func (h *Feed) checkRestored(ctx context.Context, sub *models.Subscription) error {
+	switch {
+	case sub.Name == "":
+		return fmt.Errorf("subscription name is required")
+	case h.findSubscription(sub.Name) != nil:
+		return fmt.Errorf("already exists")
+	case sub.Interval == 0:
+		sub.Interval = defaultFetchInterval
+	case sub.Interval < models.MinInterval:
+		return models.ErrInvalidIntervalDuration
+	}
+	return h.feeder.Validate(ctx, sub)
+}
+
+func (h *Feed) reply(ctx context.Context, threadId int, text string) error {
+	return h.contentPublisher.SendData(ctx, []models.Content{
+		{
+			ThreadId: threadId,
+			Text:     text,
+		},
+	})
 }
 
 func (h *Feed) add(ctx context.Context, c models.Commander) error {
@@ -91,12 +259,24 @@ func (h *Feed) add(ctx context.Context, c models.Commander) error {
 		zap.Int("threadId", c.ThreadId()),
 	)
 
+	if c.SubName() == "" {
+		return fmt.Errorf("%s: subscription name is required", h.feeder.Name())
+	}
+	if h.findSubscription(c.SubName()) != nil {
+		return fmt.Errorf("%s: subscription %s already exists", h.feeder.Name(), c.SubName())
+	}
+
 	sub := models.Subscription{
 		Name:     c.SubName(),
 		Interval: ge.DefaultIfZero(c.Interval(), defaultFetchInterval),
 		ThreadId: c.ThreadId(),
 		Platform: c.Platform(),
 		Url:      c.Url(),
+		Options:  c.Options(),
+	}
+	if err := h.feeder.Validate(ctx, &sub); err != nil {
+		h.logger.Warn("invalid subscription", zap.String("feed", h.feeder.Name()), zap.String("name", sub.Name), zap.Error(err))
+		return fmt.Errorf("%s: cannot add %s: %w", h.feeder.Name(), sub.Name, err)
 	}
 	if err := h.saveSubscription(ctx, &sub); err != nil {
 		return err
@@ -107,6 +287,13 @@ func (h *Feed) add(ctx context.Context, c models.Commander) error {
 		zap.String("name", c.SubName()),
 		zap.Int("threadId", c.ThreadId()),
 	)
+	msg := fmt.Sprintf("%s: added %s", h.feeder.Name(), sub.Name)
+	if sub.Url != "" {
+		msg += fmt.Sprintf(" (%s)", sub.Url)
+	}
+	if err := h.reply(ctx, c.ThreadId(), msg); err != nil {
+		return err
+	}
 
 	h.pollFeed(ctx, &sub)
 	return nil
@@ -130,12 +317,7 @@ func (h *Feed) list(ctx context.Context, c models.Commander) error {
 
 	if len(h.subscriptions) == 0 {
 		h.logger.Info("no subscriptions")
-		return h.contentPublisher.SendData(ctx, []models.Content{
-			{
-				ThreadId: c.ThreadId(),
-				Text:     "No subscriptions",
-			},
-		})
+		return h.reply(ctx, c.ThreadId(), "No subscriptions")
 	}
 
 	var messages []string
@@ -186,15 +368,7 @@ func (h *Feed) remove(ctx context.Context, cmd models.Commander) error {
 		zap.String("name", cmd.SubName()),
 		zap.Int("threadId", cmd.ThreadId()),
 	)
-	if err := h.removeSubscription(ctx, cmd); err != nil {
-		return h.contentPublisher.SendData(ctx, []models.Content{
-			{
-				ThreadId: cmd.ThreadId(),
-				Text:     err.Error(),
-			},
-		})
-	}
-	return nil
+	return h.removeSubscription(ctx, cmd)
 }
 
 func (h *Feed) removeSubscription(ctx context.Context, cmd models.Commander) error {
@@ -207,8 +381,13 @@ func (h *Feed) removeSubscription(ctx context.Context, cmd models.Commander) err
 	if err != nil {
 		return err
 	}
-	delete(h.subscriptions, cmd.SubName())
+	delete(h.subscriptions, strings.ToLower(cmd.SubName()))
 	sub.CancelFunc()
+	if remover, ok := h.feeder.(Remover); ok {
+		if err := remover.Remove(ctx, sub); err != nil {
+			h.logger.Warn("failed to release subscription", zap.String("feed", h.feeder.Name()), zap.String("name", sub.Name), zap.Error(err))
+		}
+	}
 
 	h.logger.Info(
 		"subscription removed",
@@ -216,12 +395,7 @@ func (h *Feed) removeSubscription(ctx context.Context, cmd models.Commander) err
 		zap.String("name", cmd.SubName()),
 		zap.Int("threadId", cmd.ThreadId()),
 	)
-	return h.contentPublisher.SendData(ctx, []models.Content{
-		{
-			ThreadId: cmd.ThreadId(),
-			Text:     fmt.Sprintf("%s: removed %s", h.feeder.Name(), cmd.SubName()),
-		},
-	})
+	return h.reply(ctx, cmd.ThreadId(), fmt.Sprintf("%s: removed %s", h.feeder.Name(), cmd.SubName()))
 }
 
 func (h *Feed) addSubscription(sub *models.Subscription) {
@@ -0,0 +1,207 @@
+package feeder
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+	"github.com/camopy/rss_everything/db"
+	"github.com/camopy/rss_everything/zaplog"
+)
+
+type testCommand struct {
+	action string
+	name   string
+	url    string
+}
+
+func (c testCommand) Action() string             { return c.action }
+func (c testCommand) Interval() time.Duration    { return time.Hour }
+func (c testCommand) ThreadId() int              { return 1 }
+func (c testCommand) SubName() string            { return c.name }
+func (c testCommand) Platform() string           { return "" }
+func (c testCommand) Url() string                { return c.url }
+func (c testCommand) Options() map[string]string { return nil }
+
+// testFeeder rejects subscriptions to the "bad" url and answers the "ping"
+// action.
+type testFeeder struct {
+	mu        sync.Mutex
+	validated []string
+	removed   []string
+}
+
+func (f *testFeeder) Name() string      { return "test" }
+func (f *testFeeder) TableName() string { return "test:subscriptions:" }
+
+func (f *testFeeder) Fetch(ctx context.Context, sub *models.Subscription) ([]models.Content, error) {
+	return nil, nil
+}
+
+func (f *testFeeder) Command() models.CommandSpec {
+	return models.CommandSpec{
+		Name: "test",
+		Actions: []models.ActionSpec{
+			{Name: "add", Args: []models.ArgSpec{{Name: "name"}, {Name: "url"}}},
+			{Name: "remove", Args: []models.ArgSpec{{Name: "name"}}},
+			{Name: "ping"},
+			{Name: "import"},
+		},
+	}
+}
+
+func (f *testFeeder) ParseCommand(cmd models.Command) (models.Commander, error) {
+	p, err := f.Command().Parse(cmd.Text)
+	if err != nil {
+		return nil, err
+	}
+	return testCommand{action: p.Action, name: p.Get("name"), url: p.Get("url")}, nil
+}
+
+func (f *testFeeder) Validate(ctx context.Context, sub *models.Subscription) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.validated = append(f.validated, sub.Name)
+	if sub.Url == "bad" {
+		return errors.New("unreachable")
+	}
+	return nil
+}
+
+func (f *testFeeder) Remove(ctx context.Context, sub *models.Subscription) error {
+	f.removed = append(f.removed, sub.Name)
+	return nil
+}
+
+func (f *testFeeder) HandleAction(ctx context.Context, c models.Commander, attachment *models.File) (string, error) {
+	return "pong", nil
+}
+
+// testPublisher keeps the texts of the published contents.
+type testPublisher struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+func (p *testPublisher) SendData(ctx context.Context, contents []models.Content) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range contents {
+		p.texts = append(p.texts, c.Text)
+	}
+	return nil
+}
+
+func (p *testPublisher) SendError(err error) {}
+
+func (p *testPublisher) last() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.texts[len(p.texts)-1]
+}
+
+func newTestFeed(t *testing.T) (*Feed, *testFeeder, *testPublisher) {
+	t.Helper()
+	f, pub := &testFeeder{}, &testPublisher{}
+	return New(zaplog.L(), pub, db.NewMemory(), f), f, pub
+}
+
+func TestFeedAdd(t *testing.T) {
+	feed, f, pub := newTestFeed(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.NoError(t, feed.HandleCommand(ctx, models.Command{ThreadId: 1, Text: "add news https://news.example"}))
+	assert.Equal(t, "test: added news (https://news.example)", pub.last())
+
+	err := feed.HandleCommand(ctx, models.Command{ThreadId: 1, Text: "add NEWS https://other.example"})
+	assert.ErrorContains(t, err, "subscription NEWS already exists")
+	assert.Equal(t, err.Error(), pub.last())
+
+	err = feed.HandleCommand(ctx, models.Command{ThreadId: 1, Text: "add"})
+	assert.ErrorContains(t, err, "subscription name is required")
+
+	err = feed.HandleCommand(ctx, models.Command{ThreadId: 1, Text: "add broken bad"})
+	assert.ErrorContains(t, err, "test: cannot add broken: unreachable")
+	assert.Nil(t, feed.findSubscription("broken"))
+
+	subs, err := feed.Backup(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, subs, 1)
+	assert.Equal(t, "news", subs[0].Name)
+	assert.Equal(t, []string{"news", "broken"}, f.validated)
+}
+
+func TestFeedRestore(t *testing.T) {
+	feed, f, _ := newTestFeed(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.NoError(t, feed.HandleCommand(ctx, models.Command{ThreadId: 1, Text: "add news https://news.example"}))
+	f.validated = nil
+
+	added, skipped, err := feed.Restore(ctx, []models.Subscription{
+		{Name: "News", Url: "https://news.example"},
+		{Name: "offline", Url: "bad"},
+		{Name: "fast", Url: "https://fast.example", Interval: 10 * time.Minute},
+		{Name: ""},
+		{Name: "blog", Url: "https://blog.example"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, []string{
+		"News: already exists",
+		"offline: unreachable",
+		"fast: " + models.ErrInvalidIntervalDuration.Error(),
+		"unnamed: subscription name is required",
+	}, skipped)
+	assert.Equal(t, []string{"offline", "blog"}, f.validated)
+
+	subs, err := feed.Backup(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, subs, 2)
+	assert.Nil(t, feed.findSubscription("offline"))
+	blog := feed.findSubscription("blog")
+	assert.NotNil(t, blog)
+	assert.Equal(t, defaultFetchInterval, blog.Interval)
+}
+
+func TestFeedActions(t *testing.T) {
+	feed, _, pub := newTestFeed(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.NoError(t, feed.HandleCommand(ctx, models.Command{ThreadId: 1, Text: "ping"}))
+	assert.Equal(t, "pong", pub.last())
+
+	err := feed.HandleCommand(ctx, models.Command{ThreadId: 1, Text: "import"})
+	assert.ErrorContains(t, err, "test: import is not supported")
+
+	assert.NoError(t, feed.HandleCommand(ctx, models.Command{ThreadId: 1, Text: "help"}))
+	assert.Contains(t, pub.last(), "ping")
+}
+
+func TestFeedRemove(t *testing.T) {
+	feed, f, pub := newTestFeed(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	assert.NoError(t, feed.HandleCommand(ctx, models.Command{ThreadId: 1, Text: "add News https://news.example"}))
+	assert.NoError(t, feed.HandleCommand(ctx, models.Command{ThreadId: 1, Text: "add blog https://blog.example"}))
+	assert.NoError(t, feed.HandleCommand(ctx, models.Command{ThreadId: 1, Text: "remove news"}))
+	assert.Equal(t, "test: removed news", pub.last())
+	assert.Nil(t, feed.findSubscription("news"))
+	assert.Equal(t, []string{"News"}, f.removed)
+
+	// stopping the bot does not release the subscriptions
+	cancel()
+	assert.Equal(t, []string{"News"}, f.removed)
+
+	subs, err := feed.Backup(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, subs, 1)
+}
@@ -0,0 +1,104 @@
+package hacker_news
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"go.uber.org/zap"
+
+	"github.com/camopy/rss_everything/metrics"
+)
+
+const (
+	requestTimeout     = 10 * time.Second
+	requestAttempts    = 3
+	requestRetryDelay  = 500 * time.Millisecond
+	maxParallelFetches = 8
+)
+
+type statusError struct {
+	url    string
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("hacker-news: %s returned status %d", e.url, e.status)
+}
+
+// getJSON decodes the json body of url into v. Each attempt times out after
+// requestTimeout, and network errors and 5xx responses are retried.
+func (h *HackerNews) getJSON(ctx context.Context, url string, v any) error {
+	return retry.Do(
+		func() error {
+			return h.tryGetJSON(ctx, url, v)
+		},
+		retry.Context(ctx),
+		retry.Attempts(requestAttempts),
+		retry.Delay(requestRetryDelay),
+		retry.LastErrorOnly(true),
+		retry.OnRetry(func(n uint, err error) {
+			h.logger.Warn("hacker news request failed, retrying...", zap.String("url", url), zap.Uint("attempt", n), zap.Error(err))
+		}),
+	)
+}
+
+func (h *HackerNews) tryGetJSON(ctx context.Context, url string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return retry.Unrecoverable(err)
+	}
+	start := time.Now()
+	resp, err := h.Do(req)
+	if err != nil {
+		metrics.TrackFailedExternalRequest(http.MethodGet, req.URL.Host, time.Since(start))
+		return err
+	}
+	defer resp.Body.Close()
+
+	metrics.TrackExternalRequest(http.MethodGet, req.URL.Host, resp.StatusCode, time.Since(start))
+	if resp.StatusCode != http.StatusOK {
+		err := &statusError{url: url, status: resp.StatusCode}
+		if resp.StatusCode >= 500 {
+			return err
+		}
+		return retry.Unrecoverable(err)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// fetchStories fetches the given stories with at most maxParallelFetches
+// requests at a time. Stories that fail are logged and left nil.
+func (h *HackerNews) fetchStories(ctx context.Context, ids []int) []*Story {
+	stories := make([]*Story, len(ids))
+	sem := make(chan struct{}, maxParallelFetches)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			story, err := h.fetchStory(ctx, id)
+			if err != nil {
+				h.logger.Error("fetch story error", zap.Int("id", id), zap.Error(err))
+				return
+			}
+			stories[i] = story
+		}()
+	}
+	wg.Wait()
+	return stories
+}
@@ -0,0 +1,82 @@
+package hacker_news
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+	"github.com/camopy/rss_everything/db"
+	"github.com/camopy/rss_everything/zaplog"
+)
+
+func TestFetchRetriesAndParallelism(t *testing.T) {
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	inFlight, maxInFlight := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts[r.URL.Path]++
+		attempt := attempts[r.URL.Path]
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+
+		switch r.URL.Path {
+		case "/topstories.json":
+			_, _ = w.Write([]byte("[1,2,3,4,5,6,7,8,9,10,11,12]"))
+		case "/item/1.json":
+			if attempt == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			fallthrough
+		default:
+			time.Sleep(20 * time.Millisecond)
+			var id int
+			_, _ = fmt.Sscanf(r.URL.Path, "/item/%d.json", &id)
+			_, _ = fmt.Fprintf(w, `{"id":%d,"title":"story %d","score":%d}`, id, id, id)
+		}
+	}))
+	defer srv.Close()
+
+	h := New(zaplog.L(), db.NewMemory(), WithApiUrl(srv.URL)).(*HackerNews)
+	sub := &models.Subscription{Name: "top"}
+	contents, err := h.Fetch(context.Background(), sub)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 12)
+	assert.Contains(t, contents[0].Text, "story 1")
+	assert.Equal(t, 2, attempts["/item/1.json"])
+	assert.LessOrEqual(t, maxInFlight, maxParallelFetches)
+	assert.Greater(t, maxInFlight, 1)
+}
+
+func TestFetchHonoursContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	h := New(zaplog.L(), db.NewMemory(), WithApiUrl(srv.URL)).(*HackerNews)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := h.Fetch(ctx, &models.Subscription{Name: "top"})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -21,11 +20,10 @@ import (
 
 const (
 	hackerNews                   = "hacker_news"
-	topStoriesLimit              = 20
 	hackerNewsSubscriptionsTable = "hackernews:subscriptions:"
 
-	topStoriesEndpoint = "https://hacker-news.firebaseio.com/v0/topstories.json"
-	storyEndpoint      = "https://hacker-news.firebaseio.com/v0/item/%d.json"
+	apiBaseUrl    = "https://hacker-news.firebaseio.com/v0"
+	storyEndpoint = "/item/%d.json"
 )
 
 var hackerNewsMetrics = struct {
@@ -51,18 +49,42 @@ func trackLoadedStories(storiesLoaded int) {
 	hackerNewsMetrics.loadStoriesTotal.WithLabelValues().Inc()
 }
 
-func New(logger *zaplog.Logger, db db.DB) feeder.Feeder {
-	return &HackerNews{
-		Client: http.DefaultClient,
-		logger: logger,
-		db:     db,
+type Option func(*HackerNews)
+
+// WithApiUrl sets the base url of the Hacker News Firebase API.
+func WithApiUrl(url string) Option {
+	return func(h *HackerNews) {
+		h.apiBaseUrl = url
+	}
+}
+
+// WithSearchUrl sets the base url of the Algolia HN Search API.
+func WithSearchUrl(url string) Option {
+	return func(h *HackerNews) {
+		h.searchBaseUrl = url
 	}
 }
 
+func New(logger *zaplog.Logger, db db.DB, opts ...Option) feeder.Feeder {
+	h := &HackerNews{
+		Client:        &http.Client{Timeout: requestTimeout},
+		logger:        logger,
+		db:            db,
+		apiBaseUrl:    apiBaseUrl,
+		searchBaseUrl: searchBaseUrl,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
 type HackerNews struct {
 	*http.Client
-	logger *zaplog.Logger
-	db     db.DB
+	logger        *zaplog.Logger
+	db            db.DB
+	apiBaseUrl    string
+	searchBaseUrl string
 }
 
 func (h *HackerNews) Name() string {
@@ -78,6 +100,7 @@ type hackerNewsCommand struct {
 	action   string
 	subName  string
 	interval time.Duration
+	options  map[string]string
 }
 
 func (c hackerNewsCommand) ThreadId() int {
@@ -104,50 +127,137 @@ func (c hackerNewsCommand) Url() string {
 	return ""
 }
 
+func (c hackerNewsCommand) Options() map[string]string {
+	return c.options
+}
+
+func (h *HackerNews) Command() models.CommandSpec {
+	return models.CommandSpec{
+		Name:        "hn",
+		Aliases:     []string{"hackernews"},
+		Description: "Hacker News stories",
+		Actions: []models.ActionSpec{
+			{
+				Name:    "add",
+				Aliases: []string{"new", "subscribe"},
+				Help:    "subscribe to a story list, e.g. /hn add show-hn-popular 120 show score>=100",
+				Args: []models.ArgSpec{
+					{Name: "name", Help: "unique name of the subscription", Required: true},
+					{Name: "interval", Help: "polling interval in minutes (min 60)", Match: models.LooksLikeInterval},
+					{Name: listOption, Help: "top (default), new, best, ask, show, job or search", Match: isList},
+					{Name: "filters", Help: "score>=N, comments>=N and velocity>=N (points per hour)", Variadic: true},
+				},
+				Flags: []models.ArgSpec{
+					{Name: minScoreOption, Help: "minimum score of the stories, search needs tags without comment"},
+					{Name: minCommentsOption, Help: "minimum number of comments of the stories, search needs tags without comment"},
+					{Name: minVelocityOption, Help: "minimum points per hour, tracked across polls, to alert rising stories"},
+					{Name: limitOption, Help: fmt.Sprintf("number of stories of the list to check (default %d, max %d)", defaultLimit, maxLimit)},
+					{Name: queryOption, Help: "search: keywords to look for in stories and comments"},
+					{Name: domainOption, Help: "search: stories linking to this domain"},
+					{Name: tagsOption, Help: "search: story, comment, ask_hn, show_hn, poll or job, comma separated (default story,comment)"},
+				},
+			},
+			{
+				Name:    "remove",
+				Aliases: []string{"rm", "delete", "unsubscribe"},
+				Help:    "remove a subscription",
+				Args: []models.ArgSpec{
+					{Name: "name", Required: true},
+				},
+			},
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Help:    "list subscriptions",
+			},
+		},
+	}
+}
+
 func (h *HackerNews) ParseCommand(cmd models.Command) (models.Commander, error) {
-	s := strings.Split(cmd.Text, " ")
+	p, err := h.Command().Parse(cmd.Text)
+	if err != nil {
+		return nil, err
+	}
 
-	c := &hackerNewsCommand{
-		threadId: cmd.ThreadId,
-		action:   s[0],
+	interval, err := p.Interval("interval")
+	if err != nil {
+		return nil, fmt.Errorf("hacker-news: %w", err)
 	}
 
-	if len(s) > 1 {
-		c.subName = s[1]
+	options, err := parseQueryOptions(p)
+	if err != nil {
+		return nil, fmt.Errorf("hacker-news: %w", err)
 	}
 
-	return c, nil
+	return &hackerNewsCommand{
+		threadId: cmd.ThreadId,
+		action:   p.Action,
+		subName:  p.Get("name"),
+		interval: interval,
+		options:  options,
+	}, nil
+}
+
+func (h *HackerNews) Validate(ctx context.Context, sub *models.Subscription) error {
+	q := subscriptionQuery(sub)
+	if q.list == searchList {
+		_, err := h.search(ctx, sub, q, time.Now().Unix())
+		return err
+	}
+	_, err := h.fetchStoryIds(ctx, q)
+	return err
 }
 
+// Fetch returns the stories of the subscription list that pass its filters
+// and were not sent yet. Stories below the thresholds are not marked as seen
+// so they are sent once they reach them, which makes each story alert once.
 func (h *HackerNews) Fetch(ctx context.Context, sub *models.Subscription) ([]models.Content, error) {
-	h.logger.Info("fetching hacker news")
-	ids, err := h.fetchTopStoriesIds()
+	q := subscriptionQuery(sub)
+	h.logger.Info("fetching hacker news", zap.String("sub", sub.Name), zap.String("list", q.list))
+	if q.list == searchList {
+		return h.fetchSearch(ctx, sub, q)
+	}
+	ids, err := h.fetchStoryIds(ctx, q)
 	if err != nil {
 		return nil, err
 	}
-	stories := make([]models.Content, 0, topStoriesLimit)
-	i := 0
-	for i < len(ids) && i < topStoriesLimit {
-		id := ids[i]
-		i++
-		isDuplicate, err := h.isDuplicateStory(ctx, strconv.Itoa(id))
+
+	var newIds []int
+	for _, id := range ids[:min(len(ids), q.limit)] {
+		isDuplicate, err := h.isDuplicateStory(ctx, sub.Name, strconv.Itoa(id))
 		if err != nil {
 			return nil, err
 		}
-		if isDuplicate {
-			continue
+		if !isDuplicate {
+			newIds = append(newIds, id)
 		}
-		story, err := h.fetchStory(id)
-		if err != nil {
-			h.logger.Error("fetch story error", zap.Error(err))
+	}
+
+	stories := make([]models.Content, 0, len(newIds))
+	for _, story := range h.fetchStories(ctx, newIds) {
+		if story == nil {
 			continue
 		}
 		s := story.String()
+		if q.minVelocity > 0 {
+			velocity, err := h.trackScore(ctx, sub, story)
+			if err != nil {
+				return nil, err
+			}
+			if velocity < float64(q.minVelocity) {
+				continue
+			}
+			s = story.risingString(velocity)
+		}
+		if !q.matches(story) {
+			continue
+		}
 		value, err := json.Marshal(s)
 		if err != nil {
 			return nil, err
 		}
-		err = h.db.Set(ctx, fmt.Sprintf("%s:%s", hackerNews, strconv.Itoa(id)), value, 24*7*time.Hour)
+		err = h.db.Set(ctx, storyKey(sub.Name, strconv.Itoa(story.Id)), value, 24*7*time.Hour)
 		if err != nil {
 			return nil, err
 		}
@@ -162,29 +272,36 @@ func (h *HackerNews) Fetch(ctx context.Context, sub *models.Subscription) ([]mod
 	return stories, nil
 }
 
-func (h *HackerNews) isDuplicateStory(ctx context.Context, id string) (bool, error) {
-	s, err := h.db.Get(ctx, fmt.Sprintf("%s:%s", hackerNews, id))
-	if err != nil && !h.db.IsErrNotFound(err) {
-		return false, err
-	}
-	return s != nil, nil
+// storyKey is per subscription, so a story can be sent by several lists.
+func storyKey(subName, id string) string {
+	return fmt.Sprintf("%s:%s:%s", hackerNews, subName, id)
 }
 
-func (h *HackerNews) fetchTopStoriesIds() ([]int, error) {
-	start := time.Now()
-	resp, err := h.Get(topStoriesEndpoint)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+func legacyStoryKey(id string) string {
+	return fmt.Sprintf("%s:%s", hackerNews, id)
+}
 
-	metrics.TrackExternalRequest(http.MethodGet, resp.Request.URL.Host, resp.StatusCode, time.Since(start))
+// isDuplicateStory reports whether the subscription sent the story already.
+// Stories sent before they were tracked per subscription are only marked
+// as sent under the shared key.
+func (h *HackerNews) isDuplicateStory(ctx context.Context, subName, id string) (bool, error) {
+	for _, key := range []string{storyKey(subName, id), legacyStoryKey(id)} {
+		s, err := h.db.Get(ctx, key)
+		if err != nil && !h.db.IsErrNotFound(err) {
+			return false, err
+		}
+		if s != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
 
+func (h *HackerNews) fetchStoryIds(ctx context.Context, q storyQuery) ([]int, error) {
 	var ids []int
-	if err := json.NewDecoder(resp.Body).Decode(&ids); err != nil {
+	if err := h.getJSON(ctx, h.apiBaseUrl+q.endpoint(), &ids); err != nil {
 		return nil, err
 	}
-
 	return ids, nil
 }
 
@@ -200,21 +317,14 @@ type Story struct {
 	Url         string `json:"url"`
 }
 
-func (h *HackerNews) fetchStory(id int) (*Story, error) {
-	start := time.Now()
-	resp, err := h.Get(fmt.Sprintf(storyEndpoint, id))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	metrics.TrackExternalRequest(http.MethodGet, resp.Request.URL.Host, resp.StatusCode, time.Since(start))
-
+func (h *HackerNews) fetchStory(ctx context.Context, id int) (*Story, error) {
 	var s Story
-	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+	if err := h.getJSON(ctx, h.apiBaseUrl+fmt.Sprintf(storyEndpoint, id), &s); err != nil {
 		return nil, err
 	}
-
+	if s.Id == 0 {
+		return nil, fmt.Errorf("hacker-news: story %d not found", id)
+	}
 	return &s, nil
 }
 
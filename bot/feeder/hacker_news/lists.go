@@ -0,0 +1,145 @@
+package hacker_news
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+const (
+	listOption        = "list"
+	minScoreOption    = "min_score"
+	minCommentsOption = "min_comments"
+	limitOption       = "limit"
+	minVelocityOption = "min_velocity"
+
+	defaultList  = "top"
+	defaultLimit = 20
+	maxLimit     = 100
+
+	listsEndpoint = "/%sstories.json"
+)
+
+// lists maps the accepted list names to the name of their endpoint.
+var lists = map[string]string{
+	"top":   "top",
+	"front": "top",
+	"new":   "new",
+	"best":  "best",
+	"ask":   "ask",
+	"show":  "show",
+	"job":   "job",
+	"jobs":  "job",
+	// search is not a list but a keyword search, see search.go.
+	searchList: searchList,
+}
+
+func isList(s string) bool {
+	_, ok := lists[strings.ToLower(s)]
+	return ok
+}
+
+// storyQuery selects the stories of a subscription.
+type storyQuery struct {
+	list        string
+	limit       int
+	minScore    int
+	minComments int
+	// minVelocity is the points per hour a story must gain to be sent, see
+	// rising.go. Zero disables score tracking.
+	minVelocity int
+}
+
+func subscriptionQuery(sub *models.Subscription) storyQuery {
+	q := storyQuery{
+		list:  defaultList,
+		limit: defaultLimit,
+	}
+	if list, ok := lists[sub.Options[listOption]]; ok {
+		q.list = list
+	}
+	if n, err := strconv.Atoi(sub.Options[limitOption]); err == nil && n > 0 {
+		q.limit = min(n, maxLimit)
+	}
+	q.minScore, _ = strconv.Atoi(sub.Options[minScoreOption])
+	q.minComments, _ = strconv.Atoi(sub.Options[minCommentsOption])
+	q.minVelocity, _ = strconv.Atoi(sub.Options[minVelocityOption])
+	return q
+}
+
+func (q storyQuery) endpoint() string {
+	return fmt.Sprintf(listsEndpoint, q.list)
+}
+
+func (q storyQuery) matches(s *Story) bool {
+	return s.Score >= q.minScore && s.Descendants >= q.minComments
+}
+
+// parseQueryOptions returns the subscription options of the list, filters
+// and flags of an add command. Filters are written as score>=100,
+// comments>10 or velocity>=50.
+func parseQueryOptions(p models.ParsedCommand) (map[string]string, error) {
+	opts := p.Options(listOption, minScoreOption, minCommentsOption, minVelocityOption, limitOption, queryOption, tagsOption, domainOption)
+	if opts == nil {
+		opts = make(map[string]string)
+	}
+	if list, ok := opts[listOption]; ok {
+		if !isList(list) {
+			return nil, fmt.Errorf("unknown list %q, use top, new, best, ask, show, job or search", list)
+		}
+		opts[listOption] = lists[strings.ToLower(list)]
+	}
+
+	for _, filter := range strings.Fields(p.Get("filters")) {
+		name, value, err := parseFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		opts[name] = strconv.Itoa(value)
+	}
+
+	for _, name := range []string{minScoreOption, minCommentsOption, minVelocityOption, limitOption} {
+		if v, ok := opts[name]; ok {
+			if n, err := strconv.Atoi(v); err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, v)
+			}
+		}
+	}
+	if err := validateSearchOptions(opts); err != nil {
+		return nil, err
+	}
+	if len(opts) == 0 {
+		return nil, nil
+	}
+	return opts, nil
+}
+
+func parseFilter(filter string) (name string, value int, err error) {
+	field, op, rest := "", "", ""
+	for _, o := range []string{">=", ">"} {
+		if f, r, ok := strings.Cut(filter, o); ok {
+			field, op, rest = strings.ToLower(f), o, r
+			break
+		}
+	}
+	switch field {
+	case "score", "points":
+		name = minScoreOption
+	case "comments":
+		name = minCommentsOption
+	case "velocity":
+		name = minVelocityOption
+	default:
+		return "", 0, fmt.Errorf("invalid filter %q, use score>=N, comments>=N or velocity>=N", filter)
+	}
+	value, err = strconv.Atoi(rest)
+	if err != nil || value < 0 {
+		return "", 0, fmt.Errorf("invalid filter %q, use score>=N, comments>=N or velocity>=N", filter)
+	}
+	if op == ">" {
+		value++
+	}
+	return name, value, nil
+}
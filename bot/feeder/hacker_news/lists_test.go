@@ -0,0 +1,73 @@
+package hacker_news
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+	"github.com/camopy/rss_everything/db"
+	"github.com/camopy/rss_everything/zaplog"
+)
+
+func TestParseCommandListAndFilters(t *testing.T) {
+	h := &HackerNews{}
+
+	c, err := h.ParseCommand(models.Command{Text: "add show-hn-popular 120 show score>=100 comments>9 limit=50"})
+	assert.NoError(t, err)
+	assert.Equal(t, "show-hn-popular", c.SubName())
+	assert.Equal(t, map[string]string{
+		listOption:        "show",
+		minScoreOption:    "100",
+		minCommentsOption: "10",
+		limitOption:       "50",
+	}, c.Options())
+
+	c, err = h.ParseCommand(models.Command{Text: "add front-page front"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{listOption: "top"}, c.Options())
+
+	c, err = h.ParseCommand(models.Command{Text: "add top"})
+	assert.NoError(t, err)
+	assert.Nil(t, c.Options())
+
+	_, err = h.ParseCommand(models.Command{Text: "add foo show votes>=1"})
+	assert.ErrorContains(t, err, "invalid filter")
+}
+
+func TestSubscriptionQuery(t *testing.T) {
+	q := subscriptionQuery(&models.Subscription{})
+	assert.Equal(t, storyQuery{list: defaultList, limit: defaultLimit}, q)
+	assert.Equal(t, "/topstories.json", q.endpoint())
+
+	q = subscriptionQuery(&models.Subscription{Options: map[string]string{
+		listOption:     "job",
+		limitOption:    "500",
+		minScoreOption: "10",
+	}})
+	assert.Equal(t, "/jobstories.json", q.endpoint())
+	assert.Equal(t, maxLimit, q.limit)
+	assert.True(t, q.matches(&Story{Score: 10}))
+	assert.False(t, q.matches(&Story{Score: 9}))
+}
+
+func TestIsDuplicateStory(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemory()
+	h := New(zaplog.L(), store).(*HackerNews)
+
+	// story 12 was sent before stories were tracked per subscription
+	assert.NoError(t, store.Set(ctx, legacyStoryKey("12"), []byte("story 12"), time.Hour))
+	assert.NoError(t, store.Set(ctx, storyKey("top", "13"), []byte("story 13"), time.Hour))
+
+	for id, want := range map[string]bool{"12": true, "13": true, "14": false} {
+		got, err := h.isDuplicateStory(ctx, "top", id)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got, id)
+	}
+	got, err := h.isDuplicateStory(ctx, "best", "13")
+	assert.NoError(t, err)
+	assert.False(t, got)
+}
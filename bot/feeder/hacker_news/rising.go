@@ -0,0 +1,65 @@
+package hacker_news
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+const (
+	snapshotTTL = 48 * time.Hour
+	// minVelocityWindow keeps a few early votes from looking like a rising
+	// story.
+	minVelocityWindow = 15 * time.Minute
+)
+
+// scoreSnapshot is the score of a story at the previous poll.
+type scoreSnapshot struct {
+	Score int       `json:"score"`
+	At    time.Time `json:"at"`
+}
+
+func snapshotKey(subName string, id int) string {
+	return fmt.Sprintf("%s:%s:snapshot:%d", hackerNews, subName, id)
+}
+
+// trackScore saves the current score of the story and returns the points it
+// gained per hour since the previous snapshot, or since it was posted when
+// it is seen for the first time.
+func (h *HackerNews) trackScore(ctx context.Context, sub *models.Subscription, story *Story) (float64, error) {
+	now := time.Now()
+	prev := scoreSnapshot{At: time.Unix(int64(story.Time), 0)}
+	b, err := h.db.Get(ctx, snapshotKey(sub.Name, story.Id))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, &prev); err != nil {
+			return 0, err
+		}
+	case !h.db.IsErrNotFound(err):
+		return 0, err
+	}
+
+	value, err := json.Marshal(scoreSnapshot{Score: story.Score, At: now})
+	if err != nil {
+		return 0, err
+	}
+	if err := h.db.Set(ctx, snapshotKey(sub.Name, story.Id), value, snapshotTTL); err != nil {
+		return 0, err
+	}
+
+	elapsed := max(now.Sub(prev.At), minVelocityWindow)
+	return float64(story.Score-prev.Score) / elapsed.Hours(), nil
+}
+
+func (s *Story) risingString(velocity float64) string {
+	hnUrl := "https://news.ycombinator.com/item?id="
+	return fmt.Sprintf(`
+HN 🚀: %s - ⬆️%d (+%.0f/h)
+%s
+
+%s
+	`, s.Title, s.Score, velocity, s.Url, fmt.Sprintf("%s%d", hnUrl, s.Id))
+}
@@ -0,0 +1,56 @@
+package hacker_news
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+	"github.com/camopy/rss_everything/db"
+	"github.com/camopy/rss_everything/zaplog"
+)
+
+func TestRisingStories(t *testing.T) {
+	posted := time.Now().Add(-time.Hour).Unix()
+	score := 30
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/newstories.json":
+			_, _ = w.Write([]byte("[1]"))
+		case "/item/1.json":
+			_, _ = fmt.Fprintf(w, `{"id":1,"title":"Rising","score":%d,"time":%d}`, score, posted)
+		}
+	}))
+	defer srv.Close()
+
+	h := New(zaplog.L(), db.NewMemory(), WithApiUrl(srv.URL)).(*HackerNews)
+	c, err := h.ParseCommand(models.Command{Text: "add rising new velocity>=50"})
+	assert.NoError(t, err)
+	sub := &models.Subscription{Name: c.SubName(), Options: c.Options()}
+	ctx := context.Background()
+
+	contents, err := h.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+
+	// pretend the previous poll was an hour ago
+	value, err := json.Marshal(scoreSnapshot{Score: 30, At: time.Now().Add(-time.Hour)})
+	assert.NoError(t, err)
+	assert.NoError(t, h.db.Set(ctx, snapshotKey(sub.Name, 1), value, snapshotTTL))
+	score = 100
+
+	contents, err = h.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 1)
+	assert.Contains(t, contents[0].Text, "HN 🚀: Rising - ⬆️100 (+70/h)")
+
+	contents, err = h.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+}
@@ -0,0 +1,249 @@
+package hacker_news
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+
+	"github.com/camopy/rss_everything/bot/models"
+	ge "github.com/camopy/rss_everything/util/generics"
+)
+
+const (
+	searchList    = "search"
+	queryOption   = "query"
+	tagsOption    = "tags"
+	domainOption  = "domain"
+	defaultTags   = "story,comment"
+	searchBaseUrl = "https://hn.algolia.com/api/v1"
+
+	// firstSearchWindow is how far back the first run of a search looks.
+	firstSearchWindow = 24 * time.Hour
+	searchCursorTTL   = 30 * 24 * time.Hour
+	// maxSearchPages bounds the pages of hits read in one poll.
+	maxSearchPages   = 10
+	maxCommentLength = 300
+)
+
+var (
+	searchTags = []string{"story", "comment", "ask_hn", "show_hn", "poll", "job"}
+	htmlTagRe  = regexp.MustCompile(`<[^>]*>`)
+)
+
+// searchHit is a story or comment returned by the Algolia HN Search API.
+type searchHit struct {
+	ObjectID    string `json:"objectID"`
+	Title       string `json:"title"`
+	Url         string `json:"url"`
+	Author      string `json:"author"`
+	Points      int    `json:"points"`
+	NumComments int    `json:"num_comments"`
+	CommentText string `json:"comment_text"`
+	StoryTitle  string `json:"story_title"`
+	CreatedAtI  int64  `json:"created_at_i"`
+}
+
+type searchResult struct {
+	Hits    []searchHit `json:"hits"`
+	NbPages int         `json:"nbPages"`
+}
+
+func validateSearchOptions(opts map[string]string) error {
+	isSearch := opts[listOption] == searchList
+	for _, name := range []string{queryOption, tagsOption, domainOption} {
+		if _, ok := opts[name]; ok && !isSearch {
+			return fmt.Errorf("%s is only supported by the search list", name)
+		}
+	}
+	if !isSearch {
+		return nil
+	}
+	if _, ok := opts[minVelocityOption]; ok {
+		return fmt.Errorf("%s is not supported by the search list", minVelocityOption)
+	}
+	if opts[queryOption] == "" && opts[domainOption] == "" {
+		return fmt.Errorf(`search requires a query or a domain, e.g. query="my product"`)
+	}
+	if opts[queryOption] != "" && opts[domainOption] != "" {
+		return fmt.Errorf("search accepts either a query or a domain")
+	}
+	if tags, ok := opts[tagsOption]; ok {
+		for _, tag := range strings.Split(tags, ",") {
+			if !slices.Contains(searchTags, strings.TrimSpace(tag)) {
+				return fmt.Errorf("unknown tag %q, use %s", tag, strings.Join(searchTags, ", "))
+			}
+		}
+	}
+	// Comment hits have no points nor comments, the filters would drop them.
+	for _, name := range []string{minScoreOption, minCommentsOption} {
+		if _, ok := opts[name]; ok && searchesComments(opts) {
+			return fmt.Errorf("%s does not apply to comments, add tags without comment, e.g. tags=story", name)
+		}
+	}
+	return nil
+}
+
+func searchesComments(opts map[string]string) bool {
+	for _, tag := range strings.Split(ge.DefaultIfZero(opts[tagsOption], defaultTags), ",") {
+		if strings.TrimSpace(tag) == "comment" {
+			return true
+		}
+	}
+	return false
+}
+
+// searchUrl builds the search_by_date request of the subscription for a
+// page of the hits created since the given unix time.
+func (h *HackerNews) searchUrl(sub *models.Subscription, q storyQuery, since int64, page int) string {
+	params := url.Values{}
+	if domain := sub.Options[domainOption]; domain != "" {
+		params.Set("query", domain)
+		params.Set("restrictSearchableAttributes", "url")
+	} else {
+		params.Set("query", sub.Options[queryOption])
+	}
+
+	tags := strings.Split(ge.DefaultIfZero(sub.Options[tagsOption], defaultTags), ",")
+	for i := range tags {
+		tags[i] = strings.TrimSpace(tags[i])
+	}
+	params.Set("tags", ge.Cond(len(tags) == 1, tags[0], "("+strings.Join(tags, ",")+")"))
+
+	numeric := []string{fmt.Sprintf("created_at_i>=%d", since)}
+	if q.minScore > 0 {
+		numeric = append(numeric, fmt.Sprintf("points>=%d", q.minScore))
+	}
+	if q.minComments > 0 {
+		numeric = append(numeric, fmt.Sprintf("num_comments>=%d", q.minComments))
+	}
+	params.Set("numericFilters", strings.Join(numeric, ","))
+	params.Set("hitsPerPage", strconv.Itoa(q.limit))
+	params.Set("page", strconv.Itoa(page))
+	return h.searchBaseUrl + "/search_by_date?" + params.Encode()
+}
+
+// search returns the hits created since the given unix time, newest first.
+// It reads pages of q.limit hits until the last one, so no hit between the
+// time and the newest one is skipped, up to maxSearchPages.
+func (h *HackerNews) search(ctx context.Context, sub *models.Subscription, q storyQuery, since int64) ([]searchHit, error) {
+	var hits []searchHit
+	for page := 0; page < maxSearchPages; page++ {
+		var result searchResult
+		if err := h.getJSON(ctx, h.searchUrl(sub, q, since, page), &result); err != nil {
+			return nil, err
+		}
+		hits = append(hits, result.Hits...)
+		if len(result.Hits) < q.limit || page+1 >= result.NbPages {
+			return hits, nil
+		}
+	}
+	h.logger.Warn("search has more hits than read, skipping the oldest", zap.String("sub", sub.Name), zap.Int("hits", len(hits)))
+	return hits, nil
+}
+
+// fetchSearch returns the hits created since the cursor of the subscription,
+// oldest first, and moves the cursor to the newest of them. The first run
+// only looks at the last day.
+func (h *HackerNews) fetchSearch(ctx context.Context, sub *models.Subscription, q storyQuery) ([]models.Content, error) {
+	since, err := h.searchCursor(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+	if since == 0 {
+		since = time.Now().Add(-firstSearchWindow).Unix()
+	}
+
+	hits, err := h.search(ctx, sub, q, since)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := make([]models.Content, 0, len(hits))
+	cursor := since
+	for i := len(hits) - 1; i >= 0; i-- {
+		hit := hits[i]
+		cursor = max(cursor, hit.CreatedAtI)
+		isDuplicate, err := h.isDuplicateStory(ctx, sub.Name, hit.ObjectID)
+		if err != nil {
+			return nil, err
+		}
+		if isDuplicate {
+			continue
+		}
+		text := hit.String()
+		value, err := json.Marshal(text)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.db.Set(ctx, storyKey(sub.Name, hit.ObjectID), value, 24*7*time.Hour); err != nil {
+			return nil, err
+		}
+		contents = append(contents, models.Content{
+			Text:     text,
+			ThreadId: sub.ThreadId,
+		})
+	}
+
+	if err := h.saveSearchCursor(ctx, sub, cursor); err != nil {
+		return nil, err
+	}
+	h.logger.Info("searched hacker news", zap.String("sub", sub.Name), zap.Int("hits", len(contents)))
+	return contents, nil
+}
+
+func searchCursorKey(subName string) string {
+	return fmt.Sprintf("%s:%s:cursor", hackerNews, subName)
+}
+
+func (h *HackerNews) searchCursor(ctx context.Context, sub *models.Subscription) (int64, error) {
+	b, err := h.db.Get(ctx, searchCursorKey(sub.Name))
+	if err != nil {
+		if h.db.IsErrNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseInt(string(b), 10, 64)
+}
+
+func (h *HackerNews) saveSearchCursor(ctx context.Context, sub *models.Subscription, cursor int64) error {
+	return h.db.Set(ctx, searchCursorKey(sub.Name), []byte(strconv.FormatInt(cursor, 10)), searchCursorTTL)
+}
+
+func (hit searchHit) String() string {
+	link := "https://news.ycombinator.com/item?id=" + hit.ObjectID
+	if hit.CommentText != "" {
+		return fmt.Sprintf(`
+HN comment by %s on %s:
+%s
+
+%s
+	`, hit.Author, hit.StoryTitle, commentExcerpt(hit.CommentText), link)
+	}
+	return fmt.Sprintf(`
+HN: %s - ⬆️%d
+%s
+
+%s
+	`, hit.Title, hit.Points, hit.Url, link)
+}
+
+// commentExcerpt converts the html of a comment to a short plain text.
+func commentExcerpt(s string) string {
+	s = strings.ReplaceAll(s, "<p>", "\n")
+	s = strings.TrimSpace(html.UnescapeString(htmlTagRe.ReplaceAllString(s, "")))
+	if utf8.RuneCountInString(s) <= maxCommentLength {
+		return s
+	}
+	return strings.TrimSpace(string([]rune(s)[:maxCommentLength])) + "…"
+}
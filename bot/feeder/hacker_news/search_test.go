@@ -0,0 +1,132 @@
+package hacker_news
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+	"github.com/camopy/rss_everything/db"
+	"github.com/camopy/rss_everything/zaplog"
+)
+
+// fakeAlgolia serves search_by_date with the page of the hits created since
+// the created_at_i numeric filter of the request.
+func fakeAlgolia(t *testing.T, hits []searchHit, requests *[]*http.Request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/search_by_date", r.URL.Path)
+		*requests = append(*requests, r)
+
+		query := r.URL.Query()
+		var since int64
+		for _, f := range strings.Split(query.Get("numericFilters"), ",") {
+			if v, ok := strings.CutPrefix(f, "created_at_i>="); ok {
+				since, _ = strconv.ParseInt(v, 10, 64)
+			}
+		}
+		var matched []searchHit
+		for _, hit := range hits {
+			if hit.CreatedAtI >= since {
+				matched = append(matched, hit)
+			}
+		}
+		perPage, _ := strconv.Atoi(query.Get("hitsPerPage"))
+		page, _ := strconv.Atoi(query.Get("page"))
+		result := searchResult{NbPages: (len(matched) + perPage - 1) / perPage}
+		if start := page * perPage; start < len(matched) {
+			result.Hits = matched[start:min(start+perPage, len(matched))]
+		}
+		_ = json.NewEncoder(w).Encode(result)
+	}))
+}
+
+func TestSearchSubscription(t *testing.T) {
+	now := time.Now().Unix()
+	hits := []searchHit{
+		{ObjectID: "3", Author: "bob", CommentText: "I love <i>acme</i> &amp; friends", StoryTitle: "Tools", CreatedAtI: now - 60},
+		{ObjectID: "2", Title: "Acme launches", Url: "https://acme.com", Points: 12, CreatedAtI: now - 120},
+		{ObjectID: "1", Title: "Old acme news", CreatedAtI: now - 3*24*3600},
+	}
+	var requests []*http.Request
+	srv := fakeAlgolia(t, hits, &requests)
+	defer srv.Close()
+
+	h := New(zaplog.L(), db.NewMemory(), WithSearchUrl(srv.URL)).(*HackerNews)
+	c, err := h.ParseCommand(models.Command{Text: `add acme search query="acme corp" tags=story,comment`})
+	assert.NoError(t, err)
+	sub := &models.Subscription{Name: c.SubName(), Options: c.Options()}
+	assert.NoError(t, h.Validate(context.Background(), sub))
+
+	contents, err := h.Fetch(context.Background(), sub)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 2)
+	assert.Contains(t, contents[0].Text, "Acme launches")
+	assert.Contains(t, contents[1].Text, "HN comment by bob on Tools:\nI love acme & friends")
+
+	q := requests[len(requests)-1].URL.Query()
+	assert.Equal(t, "acme corp", q.Get("query"))
+	assert.Equal(t, "(story,comment)", q.Get("tags"))
+
+	contents, err = h.Fetch(context.Background(), sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+	assert.Contains(t, requests[len(requests)-1].URL.Query().Get("numericFilters"), "created_at_i>="+strconv.FormatInt(now-60, 10))
+}
+
+func TestSearchOptions(t *testing.T) {
+	h := &HackerNews{}
+
+	_, err := h.ParseCommand(models.Command{Text: "add acme search"})
+	assert.ErrorContains(t, err, "requires a query or a domain")
+
+	_, err = h.ParseCommand(models.Command{Text: "add acme top query=acme"})
+	assert.ErrorContains(t, err, "only supported by the search list")
+
+	_, err = h.ParseCommand(models.Command{Text: "add acme search domain=acme.com tags=link"})
+	assert.ErrorContains(t, err, "unknown tag")
+
+	_, err = h.ParseCommand(models.Command{Text: "add acme search query=acme score>=5"})
+	assert.ErrorContains(t, err, "min_score does not apply to comments")
+
+	_, err = h.ParseCommand(models.Command{Text: "add acme search query=acme tags=story,comment comments>=5"})
+	assert.ErrorContains(t, err, "min_comments does not apply to comments")
+
+	c, err := h.ParseCommand(models.Command{Text: "add acme search query=acme tags=story,show_hn score>=5"})
+	assert.NoError(t, err)
+	sub := &models.Subscription{Options: c.Options()}
+	searchUrl, err := url.Parse(h.searchUrl(sub, subscriptionQuery(sub), 0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "created_at_i>=0,points>=5", searchUrl.Query().Get("numericFilters"))
+}
+
+func TestSearchPages(t *testing.T) {
+	now := time.Now().Unix()
+	var hits []searchHit
+	for i := 5; i >= 1; i-- {
+		hits = append(hits, searchHit{ObjectID: strconv.Itoa(i), Title: "story " + strconv.Itoa(i), CreatedAtI: now - int64(100-i)})
+	}
+	var requests []*http.Request
+	srv := fakeAlgolia(t, hits, &requests)
+	defer srv.Close()
+
+	h := New(zaplog.L(), db.NewMemory(), WithSearchUrl(srv.URL)).(*HackerNews)
+	c, err := h.ParseCommand(models.Command{Text: `add acme search query=acme limit=2`})
+	assert.NoError(t, err)
+	sub := &models.Subscription{Name: c.SubName(), Options: c.Options()}
+
+	// the hits past the first page are read too, oldest first
+	contents, err := h.Fetch(context.Background(), sub)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 5)
+	assert.Contains(t, contents[0].Text, "story 1")
+	assert.Contains(t, contents[4].Text, "story 5")
+	assert.Len(t, requests, 3)
+}
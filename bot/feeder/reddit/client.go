@@ -0,0 +1,322 @@
+package reddit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/turnage/graw/reddit"
+
+	"github.com/camopy/rss_everything/metrics"
+)
+
+const (
+	redditUserAgent = "rss_feed:1:0.1 (by /u/BurnInNoia)"
+	publicBaseUrl   = "https://www.reddit.com"
+	// publicRequestInterval keeps the anonymous client under the limit of
+	// 10 requests per minute of the public endpoints.
+	publicRequestInterval = 6 * time.Second
+	publicRequestTimeout  = 30 * time.Second
+)
+
+// post holds the fields of a reddit post the feeder uses, whichever client
+// read it.
+type post struct {
+	ID            string `json:"id"`
+	Title         string `json:"title"`
+	Permalink     string `json:"permalink"`
+	URL           string `json:"url"`
+	Author        string `json:"author"`
+	Subreddit     string `json:"subreddit"`
+	Score         int32  `json:"score"`
+	NumComments   int32  `json:"num_comments"`
+	CreatedUTC    uint64 `json:"created_utc"`
+	LinkFlairText string `json:"link_flair_text"`
+	NSFW          bool   `json:"over_18"`
+	Spoiler       bool   `json:"spoiler"`
+	IsSelf        bool   `json:"is_self"`
+	Thumbnail     string `json:"thumbnail"`
+
+	Preview       postPreview              `json:"preview"`
+	IsGallery     bool                     `json:"is_gallery"`
+	GalleryData   galleryData              `json:"gallery_data"`
+	MediaMetadata map[string]mediaMetadata `json:"media_metadata"`
+	SecureMedia   embedMedia               `json:"secure_media"`
+}
+
+// comment holds the fields of a reddit comment the feeder uses.
+type comment struct {
+	ID         string `json:"id"`
+	Author     string `json:"author"`
+	Body       string `json:"body"`
+	Permalink  string `json:"permalink"`
+	Score      int32  `json:"score"`
+	CreatedUTC uint64 `json:"created_utc"`
+}
+
+// client reads the posts of a reddit listing such as /r/golang/new, and the
+// comments of a post.
+type client interface {
+	listing(ctx context.Context, path string, params map[string]string) ([]*post, error)
+	// comments returns the post and its newest top-level comments.
+	comments(ctx context.Context, postID string) (*post, []*comment, error)
+}
+
+// commentsParams asks for the newest top-level comments of a post.
+var commentsParams = map[string]string{
+	"sort":  "new",
+	"depth": "1",
+	"limit": "100",
+}
+
+// oauthClient reads listings through graw with the credentials of a reddit
+// script app.
+type oauthClient struct {
+	bot reddit.Bot
+}
+
+func newOAuthClient(id, secret, username, password string) (*oauthClient, error) {
+	bot, err := reddit.NewBot(reddit.BotConfig{
+		Agent: redditUserAgent,
+		App: reddit.App{
+			ID:       id,
+			Secret:   secret,
+			Username: username,
+			Password: password,
+		},
+		Client: http.DefaultClient,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &oauthClient{bot: bot}, nil
+}
+
+func (c *oauthClient) listing(_ context.Context, path string, params map[string]string) ([]*post, error) {
+	harvest, err := c.bot.ListingWithParams(path, params)
+	if err != nil {
+		return nil, err
+	}
+	posts := make([]*post, 0, len(harvest.Posts))
+	for _, p := range harvest.Posts {
+		posts = append(posts, grawPost(p))
+	}
+	return posts, nil
+}
+
+func (c *oauthClient) comments(_ context.Context, postID string) (*post, []*comment, error) {
+	harvest, err := c.bot.ListingWithParams("/comments/"+postID, commentsParams)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(harvest.Posts) != 1 {
+		return nil, nil, reddit.ThreadDoesNotExistErr
+	}
+	p := harvest.Posts[0]
+	comments := make([]*comment, 0, len(p.Replies))
+	for _, r := range p.Replies {
+		comments = append(comments, &comment{
+			ID:         r.ID,
+			Author:     r.Author,
+			Body:       r.Body,
+			Permalink:  r.Permalink,
+			Score:      r.Ups - r.Downs,
+			CreatedUTC: r.CreatedUTC,
+		})
+	}
+	return grawPost(p), comments, nil
+}
+
+func grawPost(p *reddit.Post) *post {
+	return &post{
+		ID:            p.ID,
+		Title:         p.Title,
+		Permalink:     p.Permalink,
+		URL:           p.URL,
+		Author:        p.Author,
+		Subreddit:     p.Subreddit,
+		Score:         p.Score,
+		NumComments:   p.NumComments,
+		CreatedUTC:    p.CreatedUTC,
+		LinkFlairText: p.LinkFlairText,
+		NSFW:          p.NSFW,
+		// graw does not decode the spoiler flag, reddit replaces the
+		// thumbnail of spoilers instead.
+		Spoiler:   p.Thumbnail == "spoiler",
+		IsSelf:    p.IsSelf,
+		Thumbnail: p.Thumbnail,
+		// graw neither decodes previews nor galleries, so posts read with
+		// credentials only get the images they link to and embed thumbnails.
+		SecureMedia: embedMedia{OEmbed: oEmbed{ThumbnailURL: p.SecureMedia.OEmbed.ThumbnailURL}},
+	}
+}
+
+// publicClient reads the public json listings without credentials, spacing
+// its requests to stay within the anonymous rate limit.
+type publicClient struct {
+	http     *http.Client
+	baseUrl  string
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newPublicClient() *publicClient {
+	return &publicClient{
+		http:     &http.Client{Timeout: publicRequestTimeout},
+		baseUrl:  publicBaseUrl,
+		interval: publicRequestInterval,
+	}
+}
+
+type publicListing struct {
+	Data struct {
+		Children []struct {
+			Kind string          `json:"kind"`
+			Data json.RawMessage `json:"data"`
+		} `json:"children"`
+	} `json:"data"`
+}
+
+// publicPost decodes the fractional created_utc of the json listings.
+type publicPost struct {
+	post
+	CreatedUTC float64 `json:"created_utc"`
+}
+
+type publicComment struct {
+	comment
+	CreatedUTC float64 `json:"created_utc"`
+}
+
+func (c *publicClient) listing(ctx context.Context, path string, params map[string]string) ([]*post, error) {
+	var l publicListing
+	if err := c.get(ctx, path, params, &l); err != nil {
+		return nil, err
+	}
+	posts, _, err := l.things()
+	return posts, err
+}
+
+// comments reads the thread of the post, which reddit returns as a listing
+// with the post followed by a listing of its comments.
+func (c *publicClient) comments(ctx context.Context, postID string) (*post, []*comment, error) {
+	var thread []publicListing
+	if err := c.get(ctx, "/comments/"+postID, commentsParams, &thread); err != nil {
+		return nil, nil, err
+	}
+	if len(thread) != 2 {
+		return nil, nil, fmt.Errorf("reddit: post %s not found", postID)
+	}
+	posts, _, err := thread[0].things()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(posts) != 1 {
+		return nil, nil, fmt.Errorf("reddit: post %s not found", postID)
+	}
+	_, comments, err := thread[1].things()
+	if err != nil {
+		return nil, nil, err
+	}
+	return posts[0], comments, nil
+}
+
+// things decodes the posts and comments of the listing, skipping the "more
+// comments" placeholders.
+func (l publicListing) things() ([]*post, []*comment, error) {
+	var posts []*post
+	var comments []*comment
+	for _, child := range l.Data.Children {
+		switch child.Kind {
+		case "t3":
+			var p publicPost
+			if err := json.Unmarshal(child.Data, &p); err != nil {
+				return nil, nil, err
+			}
+			p.post.CreatedUTC = uint64(p.CreatedUTC)
+			posts = append(posts, &p.post)
+		case "t1":
+			var c publicComment
+			if err := json.Unmarshal(child.Data, &c); err != nil {
+				return nil, nil, err
+			}
+			c.comment.CreatedUTC = uint64(c.CreatedUTC)
+			comments = append(comments, &c.comment)
+		}
+	}
+	return posts, comments, nil
+}
+
+func (c *publicClient) get(ctx context.Context, path string, params map[string]string, v any) error {
+	query := url.Values{"raw_json": {"1"}}
+	for k, v := range params {
+		query.Set(k, v)
+	}
+	u := c.baseUrl + strings.TrimSuffix(path, "/") + ".json?" + query.Encode()
+
+	if err := c.wait(ctx); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", redditUserAgent)
+
+	start := time.Now()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		metrics.TrackFailedExternalRequest(http.MethodGet, req.URL.Host, time.Since(start))
+		return err
+	}
+	defer resp.Body.Close()
+	metrics.TrackExternalRequest(http.MethodGet, req.URL.Host, resp.StatusCode, time.Since(start))
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		c.backOff(resp.Header.Get("Retry-After"))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("reddit: %s returned status %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// wait blocks until the next request is allowed.
+func (c *publicClient) wait(ctx context.Context) error {
+	c.mu.Lock()
+	now := time.Now()
+	at := now
+	if c.next.After(now) {
+		at = c.next
+	}
+	c.next = at.Add(c.interval)
+	c.mu.Unlock()
+
+	if delay := at.Sub(now); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (c *publicClient) backOff(retryAfter string) {
+	wait := time.Minute
+	if d, err := time.ParseDuration(retryAfter + "s"); err == nil && d > 0 {
+		wait = d
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if next := time.Now().Add(wait); next.After(c.next) {
+		c.next = next
+	}
+}
@@ -0,0 +1,52 @@
+package reddit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+	"github.com/camopy/rss_everything/db"
+	"github.com/camopy/rss_everything/zaplog"
+)
+
+const testListing = `{"kind":"Listing","data":{"children":[
+{"kind":"t3","data":{"id":"a1","title":"Go 1.99 released","permalink":"/r/golang/comments/a1/go/","url":"https://go.dev/blog","subreddit":"golang","score":120,"num_comments":30,"created_utc":%d.0,"link_flair_text":null,"over_18":false,"spoiler":false,"is_self":false}},
+{"kind":"t3","data":{"id":"a2","title":"Spoiler","permalink":"/r/golang/comments/a2/s/","url":"https://www.reddit.com/r/golang/comments/a2/s/","subreddit":"golang","score":5,"num_comments":1,"created_utc":%d.0,"spoiler":true,"is_self":true}}
+]}}`
+
+func TestPublicClient(t *testing.T) {
+	var paths []string
+	var agent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path+"?"+r.URL.RawQuery)
+		agent = r.Header.Get("User-Agent")
+		now := time.Now().Unix()
+		_, _ = w.Write([]byte(fmt.Sprintf(testListing, now, now)))
+	}))
+	defer srv.Close()
+
+	c := newPublicClient()
+	c.baseUrl = srv.URL
+	c.interval = 50 * time.Millisecond
+
+	r := New(zaplog.L(), db.NewMemory(), "", "", "", "").(*Reddit)
+	r.client = c
+	sub := &models.Subscription{Name: "/r/golang", Options: map[string]string{spoilersOption: "false"}}
+
+	start := time.Now()
+	assert.NoError(t, r.Validate(context.Background(), sub))
+	contents, err := r.Fetch(context.Background(), sub)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), c.interval)
+
+	assert.Len(t, contents, 1)
+	assert.Contains(t, contents[0].Text, "Go 1.99 released - ⬆️120")
+	assert.Equal(t, "/r/golang/hot.json?limit=50&raw_json=1", paths[1])
+	assert.Equal(t, redditUserAgent, agent)
+}
@@ -0,0 +1,86 @@
+package reddit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+const (
+	// commentTTL keeps the comments of a thread from being sent twice while
+	// they can still be among its newest ones.
+	commentTTL = 30 * 24 * time.Hour
+	// commentMaxAge skips older comments whose seen key could have expired.
+	commentMaxAge      = 7 * 24 * time.Hour
+	commentExcerptSize = 500
+)
+
+// fetchComments returns the new top-level comments of the post followed by
+// the subscription. The comments already in the thread when it is first
+// fetched are only marked as seen, so subscribing to a busy thread does not
+// flood the chat.
+func (r *Reddit) fetchComments(ctx context.Context, sub *models.Subscription, t target, q postQuery) ([]models.Content, error) {
+	post, comments, err := r.client.comments(ctx, t.postID)
+	if err != nil {
+		return nil, err
+	}
+
+	primedKey := t.seenKey(sub, "primed")
+	primed, err := r.isDuplicatePost(ctx, primedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var contents []models.Content
+	for _, c := range comments {
+		if c.Author == "[deleted]" || int(c.Score) < q.minScore {
+			continue
+		}
+		if time.Since(time.Unix(int64(c.CreatedUTC), 0)) > commentMaxAge {
+			continue
+		}
+		key := t.seenKey(sub, c.ID)
+		seen, err := r.isDuplicatePost(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if seen {
+			continue
+		}
+		if err := r.db.Set(ctx, key, []byte(c.Permalink), commentTTL); err != nil {
+			return nil, err
+		}
+		if !primed {
+			continue
+		}
+		r.logger.Info("saved new comment", zap.String("post", t.postID), zap.String("comment", c.ID))
+		contents = append(contents, models.Content{
+			ThreadId: sub.ThreadId,
+			Text:     c.String(post.Title),
+		})
+	}
+
+	if !primed {
+		if err := r.db.Set(ctx, primedKey, []byte(time.Now().Format(time.RFC3339)), postTTLForever); err != nil {
+			return nil, err
+		}
+	}
+	return contents, nil
+}
+
+func (c *comment) String(title string) string {
+	body := strings.TrimSpace(c.Body)
+	if utf8.RuneCountInString(body) > commentExcerptSize {
+		body = string([]rune(body)[:commentExcerptSize]) + "…"
+	}
+	return fmt.Sprintf(`💬 u/%s on %s
+%s
+
+https://www.reddit.com%s`, c.Author, title, body, c.Permalink)
+}
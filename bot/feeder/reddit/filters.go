@@ -0,0 +1,245 @@
+package reddit
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/camopy/rss_everything/bot/models"
+	ge "github.com/camopy/rss_everything/util/generics"
+)
+
+const (
+	sortOption         = "sort"
+	timeOption         = "time"
+	minScoreOption     = "min_score"
+	minCommentsOption  = "min_comments"
+	flairOption        = "flair"
+	excludeFlairOption = "exclude_flair"
+	nsfwOption         = "nsfw"
+	spoilersOption     = "spoilers"
+	typeOption         = "type"
+
+	defaultSort = "hot"
+	defaultTime = "day"
+
+	typeSelf = "self"
+	typeLink = "link"
+	typeAll  = "all"
+
+	// redditFilteredFetchLimit is used instead of redditFetchLimit when posts
+	// are filtered, so enough of them are left to send.
+	redditFilteredFetchLimit = 50
+)
+
+var (
+	sorts       = []string{"hot", "new", "top", "rising"}
+	timeWindows = map[string]time.Duration{
+		"hour":  time.Hour,
+		"day":   24 * time.Hour,
+		"week":  7 * 24 * time.Hour,
+		"month": 31 * 24 * time.Hour,
+		"year":  366 * 24 * time.Hour,
+		"all":   0,
+	}
+)
+
+func isSort(s string) bool {
+	return slices.Contains(sorts, strings.ToLower(s))
+}
+
+func isTimeWindow(s string) bool {
+	_, ok := timeWindows[strings.ToLower(s)]
+	return ok
+}
+
+// postQuery selects the posts of a subscription.
+type postQuery struct {
+	sort          string
+	time          string
+	minScore      int
+	minComments   int
+	flairs        []string
+	excludeFlairs []string
+	nsfw          bool
+	spoilers      bool
+	postType      string
+}
+
+func subscriptionQuery(sub *models.Subscription) postQuery {
+	q := postQuery{
+		sort:     defaultSort,
+		time:     defaultTime,
+		nsfw:     true,
+		spoilers: true,
+		postType: typeAll,
+	}
+	if isSort(sub.Options[sortOption]) {
+		q.sort = sub.Options[sortOption]
+	}
+	if isTimeWindow(sub.Options[timeOption]) {
+		q.time = sub.Options[timeOption]
+	}
+	q.minScore, _ = strconv.Atoi(sub.Options[minScoreOption])
+	q.minComments, _ = strconv.Atoi(sub.Options[minCommentsOption])
+	q.flairs = splitList(sub.Options[flairOption])
+	q.excludeFlairs = splitList(sub.Options[excludeFlairOption])
+	if v, err := strconv.ParseBool(sub.Options[nsfwOption]); err == nil {
+		q.nsfw = v
+	}
+	if v, err := strconv.ParseBool(sub.Options[spoilersOption]); err == nil {
+		q.spoilers = v
+	}
+	if t := sub.Options[typeOption]; t == typeSelf || t == typeLink {
+		q.postType = t
+	}
+	return q
+}
+
+// listing returns the path and parameters of the listing of subreddit.
+func (q postQuery) listing(subreddit string) (string, map[string]string) {
+	params := map[string]string{
+		"limit": strconv.Itoa(ge.Cond(q.isFiltered(), redditFilteredFetchLimit, redditFetchLimit)),
+	}
+	path := strings.TrimSuffix(subreddit, "/") + "/" + q.sort
+	if q.sort == "top" {
+		params["t"] = q.time
+	}
+	return path, params
+}
+
+// maxAge is how old a post can be to be sent. Top listings cover their
+// whole time window, other listings the last day.
+func (q postQuery) maxAge() time.Duration {
+	if q.sort == "top" {
+		return timeWindows[q.time]
+	}
+	return 24 * time.Hour
+}
+
+func (q postQuery) isFiltered() bool {
+	return q.minScore > 0 || q.minComments > 0 || len(q.flairs) > 0 || len(q.excludeFlairs) > 0 ||
+		!q.nsfw || !q.spoilers || q.postType != typeAll
+}
+
+func (q postQuery) matches(post *post) bool {
+	if int(post.Score) < q.minScore || int(post.NumComments) < q.minComments {
+		return false
+	}
+	flair := strings.ToLower(strings.TrimSpace(post.LinkFlairText))
+	if len(q.flairs) > 0 && !slices.Contains(q.flairs, flair) {
+		return false
+	}
+	if slices.Contains(q.excludeFlairs, flair) {
+		return false
+	}
+	if !q.nsfw && post.NSFW {
+		return false
+	}
+	if !q.spoilers && post.Spoiler {
+		return false
+	}
+	switch q.postType {
+	case typeSelf:
+		return post.IsSelf
+	case typeLink:
+		return !post.IsSelf
+	}
+	return true
+}
+
+func splitList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// parseQueryOptions returns the subscription options of the sort, time
+// window, filters and flags of an add command. Filters are written as
+// score>=100 or comments>10.
+func parseQueryOptions(p models.ParsedCommand) (map[string]string, error) {
+	opts := p.Options(
+		sortOption, timeOption, minScoreOption, minCommentsOption,
+		flairOption, excludeFlairOption, nsfwOption, spoilersOption, typeOption,
+	)
+	if opts == nil {
+		opts = make(map[string]string)
+	}
+	if v, ok := opts[sortOption]; ok {
+		if !isSort(v) {
+			return nil, fmt.Errorf("unknown sort %q, use %s", v, strings.Join(sorts, ", "))
+		}
+		opts[sortOption] = strings.ToLower(v)
+	}
+	if v, ok := opts[timeOption]; ok {
+		if !isTimeWindow(v) {
+			return nil, fmt.Errorf("unknown time %q, use hour, day, week, month, year or all", v)
+		}
+		if opts[sortOption] != "top" {
+			return nil, fmt.Errorf("time is only supported by the top sort")
+		}
+		opts[timeOption] = strings.ToLower(v)
+	}
+
+	for _, filter := range strings.Fields(p.Get("filters")) {
+		name, value, err := parseFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		opts[name] = strconv.Itoa(value)
+	}
+	for _, name := range []string{minScoreOption, minCommentsOption} {
+		if v, ok := opts[name]; ok {
+			if n, err := strconv.Atoi(v); err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, v)
+			}
+		}
+	}
+	for _, name := range []string{nsfwOption, spoilersOption} {
+		if v, ok := opts[name]; ok {
+			if _, err := strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("invalid %s %q, use true or false", name, v)
+			}
+		}
+	}
+	if v, ok := opts[typeOption]; ok && v != typeSelf && v != typeLink && v != typeAll {
+		return nil, fmt.Errorf("invalid %s %q, use self, link or all", typeOption, v)
+	}
+
+	if len(opts) == 0 {
+		return nil, nil
+	}
+	return opts, nil
+}
+
+func parseFilter(filter string) (name string, value int, err error) {
+	field, op, rest := "", "", ""
+	for _, o := range []string{">=", ">"} {
+		if f, r, ok := strings.Cut(filter, o); ok {
+			field, op, rest = strings.ToLower(f), o, r
+			break
+		}
+	}
+	switch field {
+	case "score", "upvotes":
+		name = minScoreOption
+	case "comments":
+		name = minCommentsOption
+	default:
+		return "", 0, fmt.Errorf("invalid filter %q, use score>=N or comments>=N", filter)
+	}
+	value, err = strconv.Atoi(rest)
+	if err != nil || value < 0 {
+		return "", 0, fmt.Errorf("invalid filter %q, use score>=N or comments>=N", filter)
+	}
+	if op == ">" {
+		value++
+	}
+	return name, value, nil
+}
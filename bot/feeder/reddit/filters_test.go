@@ -0,0 +1,63 @@
+package reddit
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+func TestParseCommandQuery(t *testing.T) {
+	r := &Reddit{}
+
+	c, err := r.ParseCommand(models.Command{Text: "add /r/golang 120 top week score>=100 comments>9 flair=News,Release nsfw=false"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/r/golang", c.SubName())
+	assert.Equal(t, map[string]string{
+		sortOption:        "top",
+		timeOption:        "week",
+		minScoreOption:    "100",
+		minCommentsOption: "10",
+		flairOption:       "News,Release",
+		nsfwOption:        "false",
+	}, c.Options())
+
+	c, err = r.ParseCommand(models.Command{Text: "add /r/golang"})
+	assert.NoError(t, err)
+	assert.Nil(t, c.Options())
+
+	_, err = r.ParseCommand(models.Command{Text: "add /r/golang new week"})
+	assert.ErrorContains(t, err, "only supported by the top sort")
+
+	_, err = r.ParseCommand(models.Command{Text: "add /r/golang type=video"})
+	assert.ErrorContains(t, err, "invalid type")
+}
+
+func TestPostQuery(t *testing.T) {
+	q := subscriptionQuery(&models.Subscription{})
+	path, params := q.listing("/r/golang")
+	assert.Equal(t, "/r/golang/hot", path)
+	assert.Equal(t, map[string]string{"limit": "10"}, params)
+	assert.Equal(t, 24*time.Hour, q.maxAge())
+
+	q = subscriptionQuery(&models.Subscription{Options: map[string]string{
+		sortOption:         "top",
+		timeOption:         "all",
+		minScoreOption:     "10",
+		excludeFlairOption: "meme",
+		spoilersOption:     "false",
+		typeOption:         typeSelf,
+	}})
+	path, params = q.listing("/r/golang/")
+	assert.Equal(t, "/r/golang/top", path)
+	assert.Equal(t, map[string]string{"limit": "50", "t": "all"}, params)
+	assert.Equal(t, time.Duration(0), q.maxAge())
+
+	assert.True(t, q.matches(&post{Score: 10, IsSelf: true, LinkFlairText: "Discussion"}))
+	assert.False(t, q.matches(&post{Score: 9, IsSelf: true}))
+	assert.False(t, q.matches(&post{Score: 10, IsSelf: true, LinkFlairText: "Meme"}))
+	assert.False(t, q.matches(&post{Score: 10, IsSelf: true, Spoiler: true}))
+	assert.False(t, q.matches(&post{Score: 10}))
+}
@@ -0,0 +1,87 @@
+package reddit
+
+import (
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+// maxPostMedia is the most images sent with a post, which is the size limit
+// of a Telegram media group.
+const maxPostMedia = 10
+
+var imageExtensions = []string{".jpg", ".jpeg", ".png", ".webp"}
+
+type postPreview struct {
+	Images []struct {
+		Source struct {
+			URL string `json:"url"`
+		} `json:"source"`
+	} `json:"images"`
+}
+
+type galleryData struct {
+	Items []struct {
+		MediaID string `json:"media_id"`
+	} `json:"items"`
+}
+
+// mediaMetadata describes an item of a gallery. Images have their largest
+// size in S.URL, animated ones only have videos and a gif.
+type mediaMetadata struct {
+	Status string `json:"status"`
+	Type   string `json:"e"`
+	S      struct {
+		URL string `json:"u"`
+	} `json:"s"`
+}
+
+// embedMedia is the media of posts linking to sites such as YouTube.
+type embedMedia struct {
+	OEmbed oEmbed `json:"oembed"`
+}
+
+type oEmbed struct {
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+// media returns the images of the post: the items of a gallery, the image
+// of an image post, or the preview of videos and links.
+func (p *post) media() []models.Media {
+	var urls []string
+	if p.IsGallery {
+		for _, item := range p.GalleryData.Items {
+			m, ok := p.MediaMetadata[item.MediaID]
+			if ok && m.Status == "valid" && m.Type == "Image" && m.S.URL != "" {
+				urls = append(urls, m.S.URL)
+			}
+		}
+	}
+	if len(urls) == 0 {
+		switch {
+		case isImageUrl(p.URL):
+			urls = append(urls, p.URL)
+		case len(p.Preview.Images) > 0 && p.Preview.Images[0].Source.URL != "":
+			urls = append(urls, p.Preview.Images[0].Source.URL)
+		case p.SecureMedia.OEmbed.ThumbnailURL != "":
+			urls = append(urls, p.SecureMedia.OEmbed.ThumbnailURL)
+		}
+	}
+
+	media := make([]models.Media, 0, min(len(urls), maxPostMedia))
+	for _, u := range urls[:min(len(urls), maxPostMedia)] {
+		media = append(media, models.Media{Type: models.MediaPhoto, Url: u})
+	}
+	return media
+}
+
+func isImageUrl(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "https" && u.Scheme != "http" {
+		return false
+	}
+	return slices.Contains(imageExtensions, strings.ToLower(path.Ext(u.Path)))
+}
@@ -0,0 +1,58 @@
+package reddit
+
+import (
+	"encoding/json"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+func TestPostMedia(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		urls []string
+	}{
+		{
+			name: "image",
+			json: `{"url":"https://i.redd.it/abc.jpg","preview":{"images":[{"source":{"url":"https://preview.redd.it/abc.jpg?width=640"}}]}}`,
+			urls: []string{"https://i.redd.it/abc.jpg"},
+		},
+		{
+			name: "gallery",
+			json: `{"url":"https://www.reddit.com/gallery/x1","is_gallery":true,
+				"gallery_data":{"items":[{"media_id":"m2"},{"media_id":"m1"},{"media_id":"m3"}]},
+				"media_metadata":{
+					"m1":{"status":"valid","e":"Image","s":{"u":"https://preview.redd.it/m1.jpg"}},
+					"m2":{"status":"valid","e":"Image","s":{"u":"https://preview.redd.it/m2.png"}},
+					"m3":{"status":"failed"}}}`,
+			urls: []string{"https://preview.redd.it/m2.png", "https://preview.redd.it/m1.jpg"},
+		},
+		{
+			name: "video",
+			json: `{"url":"https://v.redd.it/v1","is_video":true,"preview":{"images":[{"source":{"url":"https://external-preview.redd.it/v1.png"}}]}}`,
+			urls: []string{"https://external-preview.redd.it/v1.png"},
+		},
+		{
+			name: "embed",
+			json: `{"url":"https://youtu.be/x","secure_media":{"oembed":{"thumbnail_url":"https://i.ytimg.com/vi/x/hqdefault.jpg"}}}`,
+			urls: []string{"https://i.ytimg.com/vi/x/hqdefault.jpg"},
+		},
+		{
+			name: "self",
+			json: `{"url":"https://www.reddit.com/r/golang/comments/a1/s/","is_self":true}`,
+		},
+	}
+	for _, tt := range tests {
+		var p post
+		assert.NoError(t, json.Unmarshal([]byte(tt.json), &p), tt.name)
+		var urls []string
+		for _, m := range p.media() {
+			assert.Equal(t, models.MediaPhoto, m.Type, tt.name)
+			urls = append(urls, m.Url)
+		}
+		assert.Equal(t, tt.urls, urls, tt.name)
+	}
+}
@@ -4,12 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"strconv"
-	"strings"
 	"time"
 
-	"github.com/turnage/graw/reddit"
 	"go.uber.org/zap"
 
 	"github.com/camopy/rss_everything/bot/feeder"
@@ -21,35 +17,36 @@ import (
 const (
 	redditFetchLimit         = 10
 	redditSubscriptionsTable = "reddit:subscriptions:"
+	// postTTLForever keeps the posts of all time top listings from being
+	// sent twice.
+	postTTLForever = 2 * 366 * 24 * time.Hour
 )
 
 type Reddit struct {
-	client reddit.Bot
+	client client
 	logger *zaplog.Logger
 	db     db.DB
 }
 
+// New returns the reddit feeder. It reads the API with the given script app
+// credentials, or the public listings when they are missing or rejected.
 func New(logger *zaplog.Logger, db db.DB, id string, key string, username string, password string) feeder.Feeder {
-	cfg := reddit.BotConfig{
-		Agent: "rss_feed:1:0.1 (by /u/BurnInNoia)",
-		App: reddit.App{
-			ID:       id,
-			Secret:   key,
-			Username: username,
-			Password: password,
-		},
-		Client: http.DefaultClient,
-	}
-	bot, err := reddit.NewBot(cfg)
-	if err != nil {
-		panic(err)
-	}
-
-	return &Reddit{
-		client: bot,
+	r := &Reddit{
 		logger: logger,
 		db:     db,
 	}
+	if id != "" && key != "" && username != "" && password != "" {
+		c, err := newOAuthClient(id, key, username, password)
+		if err == nil {
+			r.client = c
+			return r
+		}
+		logger.Error("failed to create reddit oauth client, using public listings", zap.Error(err))
+	} else {
+		logger.Info("no reddit credentials, using public listings")
+	}
+	r.client = newPublicClient()
+	return r
 }
 
 func (r *Reddit) Name() string {
@@ -61,10 +58,12 @@ func (r *Reddit) TableName() string {
 }
 
 type redditCommand struct {
-	threadId  int
-	action    string
-	subreddit string
-	interval  time.Duration
+	threadId int
+	action   string
+	target   string
+	url      string
+	interval time.Duration
+	options  map[string]string
 }
 
 func (r redditCommand) Action() string {
@@ -80,7 +79,7 @@ func (r redditCommand) ThreadId() int {
 }
 
 func (r redditCommand) SubName() string {
-	return r.subreddit
+	return r.target
 }
 
 func (r redditCommand) Platform() string {
@@ -88,46 +87,165 @@ func (r redditCommand) Platform() string {
 }
 
 func (r redditCommand) Url() string {
-	return ""
+	return r.url
+}
+
+func (r redditCommand) Options() map[string]string {
+	return r.options
+}
+
+func (r *Reddit) Command() models.CommandSpec {
+	return models.CommandSpec{
+		Name:        "reddit",
+		Description: "subreddit, user and search posts, and new comments of a post",
+		Actions: []models.ActionSpec{
+			{
+				Name:    "add",
+				Aliases: []string{"new", "subscribe"},
+				Help:    "subscribe to a subreddit, e.g. /reddit add /r/golang 120 top week score>=100, or to the new comments of a post with its link",
+				Args: []models.ArgSpec{
+					{Name: "target", Help: "subreddit (r/golang), multireddit (r/golang+rust or /user/name/m/multi), user (u/name) or post link", Required: true},
+					{Name: "interval", Help: "polling interval in minutes (min 60)", Match: models.LooksLikeInterval},
+					{Name: sortOption, Help: "hot (default), new, top or rising", Match: isSort},
+					{Name: timeOption, Help: "time window of top: hour, day (default), week, month, year or all", Match: isTimeWindow},
+					{Name: "filters", Help: "score>=N and comments>=N", Variadic: true},
+				},
+				Flags: []models.ArgSpec{
+					{Name: queryOption, Help: "search the subreddit for this query instead, e.g. query=\"go generics\""},
+					{Name: minScoreOption, Help: "minimum score of the posts"},
+					{Name: minCommentsOption, Help: "minimum number of comments of the posts"},
+					{Name: flairOption, Help: "only posts with one of these flairs, comma separated"},
+					{Name: excludeFlairOption, Help: "skip posts with one of these flairs, comma separated"},
+					{Name: nsfwOption, Help: "false to skip NSFW posts"},
+					{Name: spoilersOption, Help: "false to skip spoilers"},
+					{Name: typeOption, Help: "self for text posts only, link for link posts only, all (default)"},
+				},
+			},
+			{
+				Name:    "remove",
+				Aliases: []string{"rm", "delete", "unsubscribe"},
+				Help:    "remove a subscription",
+				Args: []models.ArgSpec{
+					{Name: "target", Required: true},
+				},
+			},
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Help:    "list subscriptions",
+			},
+		},
+	}
 }
 
 func (r *Reddit) ParseCommand(cmd models.Command) (models.Commander, error) {
-	s := strings.Split(cmd.Text, " ")
+	p, err := r.Command().Parse(cmd.Text)
+	if err != nil {
+		return nil, err
+	}
 
 	c := &redditCommand{
 		threadId: cmd.ThreadId,
-		action:   s[0],
+		action:   p.Action,
+		target:   p.Get("target"),
+	}
+	if p.Action != "add" {
+		// subscriptions are named after the canonical target, so "remove
+		// r/golang" finds /r/golang
+		if t, err := parseTarget(c.target); err == nil {
+			c.target = t.String()
+		}
+		return c, nil
+	}
+
+	t, err := parseTarget(c.target)
+	if err != nil {
+		return nil, fmt.Errorf("reddit: %w", err)
+	}
+	if p.Has(queryOption) {
+		if t, err = t.search(p.Get(queryOption)); err != nil {
+			return nil, fmt.Errorf("reddit: %w", err)
+		}
+	}
+	c.target, c.url = t.String(), t.Url()
+
+	c.interval, err = p.Interval("interval")
+	if err != nil {
+		return nil, fmt.Errorf("reddit: %w", err)
 	}
 
-	if len(s) > 1 {
-		c.subreddit = s[1]
+	c.options, err = parseQueryOptions(p)
+	if err != nil {
+		return nil, fmt.Errorf("reddit: %w", err)
+	}
+	if sort, ok := c.options[sortOption]; ok && !t.supportsSort(sort) {
+		return nil, fmt.Errorf("reddit: %s cannot be sorted by %s", t, sort)
+	}
+	return c, nil
+}
 
-		if len(s) > 2 {
-			interval, err := strconv.Atoi(s[2])
-			if err != nil {
-				return nil, fmt.Errorf("reddit: invalid interval")
-			}
-			c.interval = time.Duration(interval) * time.Minute
+// subscription returns the target of the subscription and the query of its
+// posts.
+func subscription(sub *models.Subscription) (target, postQuery, error) {
+	t, err := parseTarget(sub.Name)
+	if err != nil {
+		return target{}, postQuery{}, fmt.Errorf("reddit: %w", err)
+	}
+	q := subscriptionQuery(sub)
+	if sub.Options[sortOption] == "" {
+		q.sort = t.defaultSort()
+	}
+	return t, q, nil
+}
 
-			if c.interval.Minutes() < 60 {
-				return nil, models.ErrInvalidIntervalDuration
-			}
+func (r *Reddit) Validate(ctx context.Context, sub *models.Subscription) error {
+	t, q, err := subscription(sub)
+	if err != nil {
+		return err
+	}
+	if t.kind == kindComments {
+		if _, _, err := r.client.comments(ctx, t.postID); err != nil {
+			return fmt.Errorf("reddit: post %s is not reachable: %w", t.postID, err)
 		}
+		return nil
 	}
 
-	return c, nil
+	path, params := t.listing(q)
+	params["limit"] = "1"
+	posts, err := r.client.listing(ctx, path, params)
+	if err != nil {
+		return fmt.Errorf("reddit: %s is not reachable: %w", sub.Name, err)
+	}
+	// a search can have no results yet
+	if len(posts) == 0 && t.kind != kindSearch {
+		return fmt.Errorf("reddit: %s not found or has no posts", sub.Name)
+	}
+	return nil
 }
 
+// Fetch returns the new posts of the subscription listing that pass its
+// filters. Posts that do not pass yet are not marked as seen, so they are
+// sent once they reach the thresholds.
 func (r *Reddit) Fetch(ctx context.Context, sub *models.Subscription) ([]models.Content, error) {
-	r.logger.Info("fetching posts", zap.String("subreddit", sub.Name), zap.Int("threadId", sub.ThreadId))
-	harvest, err := r.client.ListingWithParams(sub.Name, map[string]string{
-		"limit": strconv.Itoa(redditFetchLimit),
-	})
+	r.logger.Info("fetching posts", zap.String("target", sub.Name), zap.Int("threadId", sub.ThreadId))
+	t, q, err := subscription(sub)
+	if err != nil {
+		return nil, err
+	}
+	if t.kind == kindComments {
+		return r.fetchComments(ctx, sub, t, q)
+	}
+
+	path, params := t.listing(q)
+	listing, err := r.client.listing(ctx, path, params)
 	if err != nil {
 		return nil, err
 	}
 	posts := make([]models.Content, 0, redditFetchLimit)
-	for _, post := range harvest.Posts {
+	for _, post := range listing {
+		if !q.matches(post) {
+			continue
+		}
 		p := redditPost{
 			ID:         post.ID,
 			Title:      post.Title,
@@ -138,7 +256,8 @@ func (r *Reddit) Fetch(ctx context.Context, sub *models.Subscription) ([]models.
 			Subreddit:  post.Subreddit,
 		}
 
-		isNewPost, err := r.isNewPost(ctx, p)
+		key := t.seenKey(sub, p.ID)
+		isNewPost, err := r.isNewPost(ctx, key, p, q.maxAge())
 		if err != nil {
 			return nil, err
 		}
@@ -146,7 +265,7 @@ func (r *Reddit) Fetch(ctx context.Context, sub *models.Subscription) ([]models.
 			continue
 		}
 
-		if err := r.savePost(ctx, p); err != nil {
+		if err := r.savePost(ctx, key, p, q.maxAge()); err != nil {
 			return nil, err
 		}
 
@@ -155,39 +274,51 @@ func (r *Reddit) Fetch(ctx context.Context, sub *models.Subscription) ([]models.
 		posts = append(posts, models.Content{
 			ThreadId: sub.ThreadId,
 			Text:     p.String(),
+			Media:    post.media(),
 		})
 	}
 
 	return posts, nil
 }
 
-func (r *Reddit) isNewPost(ctx context.Context, post redditPost) (bool, error) {
-	isDubplicate, err := r.isDuplicatePost(ctx, post.ID)
+func (r *Reddit) isNewPost(ctx context.Context, key string, post redditPost, maxAge time.Duration) (bool, error) {
+	isDubplicate, err := r.isDuplicatePost(ctx, key)
 	if err != nil || isDubplicate {
 		return false, err
 	}
-	return !r.isOlderThanADay(post), nil
+	return !post.isOlderThan(maxAge), nil
 }
 
-func (r *Reddit) isDuplicatePost(ctx context.Context, id string) (bool, error) {
-	s, err := r.db.Get(ctx, fmt.Sprintf("%s:%s", "reddit:posts", id))
+func (r *Reddit) isDuplicatePost(ctx context.Context, key string) (bool, error) {
+	s, err := r.db.Get(ctx, key)
 	if err != nil && !r.db.IsErrNotFound(err) {
 		return false, err
 	}
 	return s != nil, nil
 }
 
-func (r *Reddit) isOlderThanADay(post redditPost) bool {
-	createdAt := time.UnixMilli(int64(post.CreatedUTC) * 1000)
-	return time.Now().Sub(createdAt) > 24*time.Hour
+// isOlderThan reports whether the post was created more than maxAge ago. A
+// zero maxAge disables the cutoff.
+func (p redditPost) isOlderThan(maxAge time.Duration) bool {
+	if maxAge == 0 {
+		return false
+	}
+	createdAt := time.UnixMilli(int64(p.CreatedUTC) * 1000)
+	return time.Now().Sub(createdAt) > maxAge
 }
 
-func (r *Reddit) savePost(ctx context.Context, post redditPost) error {
+// savePost marks the post as seen for at least a week, or for as long as
+// the listing can still return it.
+func (r *Reddit) savePost(ctx context.Context, key string, post redditPost, maxAge time.Duration) error {
 	value, err := json.Marshal(post)
 	if err != nil {
 		return err
 	}
-	return r.db.Set(ctx, fmt.Sprintf("%s:%s", "reddit:posts", post.ID), value, 7*24*time.Hour)
+	ttl := max(7*24*time.Hour, maxAge)
+	if maxAge == 0 {
+		ttl = postTTLForever
+	}
+	return r.db.Set(ctx, key, value, ttl)
 }
 
 type redditPost struct {
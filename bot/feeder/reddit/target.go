@@ -0,0 +1,207 @@
+package reddit
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/camopy/rss_everything/bot/models"
+	ge "github.com/camopy/rss_everything/util/generics"
+)
+
+const queryOption = "query"
+
+type targetKind string
+
+const (
+	kindSubreddit targetKind = "subreddit"
+	kindMulti     targetKind = "multi"
+	kindUser      targetKind = "user"
+	kindSearch    targetKind = "search"
+	kindComments  targetKind = "comments"
+)
+
+var (
+	subredditNameRe = regexp.MustCompile(`^[A-Za-z0-9_]+(\+[A-Za-z0-9_]+)*$`)
+	userNameRe      = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	postIDRe        = regexp.MustCompile(`^[a-z0-9]+$`)
+)
+
+// target is what a subscription follows: a subreddit, a multireddit, the
+// posts of a user, a search in a subreddit or the comments of a post.
+type target struct {
+	kind targetKind
+	// path is the listing path without the sort, e.g. /r/golang or
+	// /user/spez/submitted. It is the subreddit for searches.
+	path   string
+	query  string
+	postID string
+}
+
+// parseTarget reads a subscription target. It accepts reddit paths with or
+// without the leading slash and reddit urls:
+//
+//	golang, r/golang, /r/golang           subreddit
+//	r/golang+rust, /user/spez/m/langs     multireddit
+//	u/spez, /user/spez                    user posts
+//	/r/golang/search?q=generics           subreddit search
+//	https://redd.it/abc123, /comments/abc123,
+//	https://www.reddit.com/r/golang/comments/abc123/title/
+//	                                      new top-level comments of a post
+func parseTarget(s string) (target, error) {
+	s = strings.TrimSpace(s)
+	for _, prefix := range []string{"https://", "http://"} {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	var host string
+	if !strings.HasPrefix(s, "/") {
+		if h, rest, ok := strings.Cut(s, "/"); ok && strings.Contains(h, ".") {
+			host, s = strings.ToLower(h), rest
+		} else if strings.Contains(s, ".") {
+			host, s = strings.ToLower(s), ""
+		}
+	}
+	s, rawQuery, _ := strings.Cut(s, "?")
+	segments := strings.FieldsFunc(s, func(r rune) bool { return r == '/' })
+
+	if host == "redd.it" {
+		if len(segments) == 1 && postIDRe.MatchString(segments[0]) {
+			return target{kind: kindComments, path: "/comments/" + segments[0], postID: segments[0]}, nil
+		}
+		return target{}, fmt.Errorf("invalid reddit link %q", s)
+	}
+	if host != "" && host != "reddit.com" && !strings.HasSuffix(host, ".reddit.com") {
+		return target{}, fmt.Errorf("%s is not a reddit link", host)
+	}
+
+	invalid := fmt.Errorf("invalid target %q, use a subreddit (r/golang), a multireddit (r/golang+rust), a user (u/name) or a post link", s)
+	switch {
+	case len(segments) == 1 && subredditNameRe.MatchString(segments[0]) && host == "":
+		return subredditTarget(segments[0]), nil
+	case len(segments) >= 1 && segments[0] == "comments":
+		if len(segments) < 2 || !postIDRe.MatchString(segments[1]) {
+			return target{}, invalid
+		}
+		return target{kind: kindComments, path: "/comments/" + segments[1], postID: segments[1]}, nil
+	case len(segments) >= 2 && segments[0] == "r":
+		if !subredditNameRe.MatchString(segments[1]) {
+			return target{}, invalid
+		}
+		t := subredditTarget(segments[1])
+		switch {
+		case len(segments) == 2:
+			return t, nil
+		case len(segments) == 3 && segments[2] == "search":
+			values, err := url.ParseQuery(rawQuery)
+			if err != nil || strings.TrimSpace(values.Get("q")) == "" {
+				return target{}, fmt.Errorf("search %q has no query", s)
+			}
+			return t.search(values.Get("q"))
+		case len(segments) >= 4 && segments[2] == "comments" && postIDRe.MatchString(segments[3]):
+			return target{kind: kindComments, path: "/comments/" + segments[3], postID: segments[3]}, nil
+		}
+	case len(segments) >= 2 && (segments[0] == "u" || segments[0] == "user"):
+		if !userNameRe.MatchString(segments[1]) {
+			return target{}, invalid
+		}
+		switch {
+		case len(segments) == 2 || len(segments) == 3 && segments[2] == "submitted":
+			return target{kind: kindUser, path: "/user/" + segments[1] + "/submitted"}, nil
+		case len(segments) == 4 && segments[2] == "m" && userNameRe.MatchString(segments[3]):
+			return target{kind: kindMulti, path: "/user/" + segments[1] + "/m/" + segments[3]}, nil
+		}
+	}
+	return target{}, invalid
+}
+
+func subredditTarget(name string) target {
+	return target{
+		kind: ge.Cond(strings.Contains(name, "+"), kindMulti, kindSubreddit),
+		path: "/r/" + name,
+	}
+}
+
+// search turns a subreddit or multireddit target into a search restricted
+// to it.
+func (t target) search(query string) (target, error) {
+	query = strings.TrimSpace(query)
+	if t.kind != kindSubreddit && t.kind != kindMulti || !strings.HasPrefix(t.path, "/r/") {
+		return target{}, fmt.Errorf("only subreddits can be searched")
+	}
+	if query == "" {
+		return target{}, fmt.Errorf("search query is empty")
+	}
+	return target{kind: kindSearch, path: t.path, query: query}, nil
+}
+
+// String returns the canonical form of the target, which is used as the
+// subscription name.
+func (t target) String() string {
+	switch t.kind {
+	case kindUser:
+		return strings.TrimSuffix(t.path, "/submitted")
+	case kindSearch:
+		return t.path + "/search?q=" + url.QueryEscape(t.query)
+	}
+	return t.path
+}
+
+func (t target) Url() string {
+	return publicBaseUrl + t.String()
+}
+
+// listing returns the path and parameters of the listing of the target.
+func (t target) listing(q postQuery) (string, map[string]string) {
+	switch t.kind {
+	case kindUser, kindSearch:
+		path := t.path
+		params := map[string]string{
+			"limit": strconv.Itoa(ge.Cond(q.isFiltered(), redditFilteredFetchLimit, redditFetchLimit)),
+			"sort":  q.sort,
+		}
+		if q.sort == "top" {
+			params["t"] = q.time
+		}
+		if t.kind == kindSearch {
+			path += "/search"
+			params["q"] = t.query
+			params["restrict_sr"] = "1"
+		}
+		return path, params
+	}
+	return q.listing(t.path)
+}
+
+// defaultSort is the sort of the target when the subscription has none.
+// Searches default to the newest results instead of the hot ones.
+func (t target) defaultSort() string {
+	return ge.Cond(t.kind == kindSearch, "new", defaultSort)
+}
+
+// supportsSort reports whether the target listing can be sorted by sort.
+func (t target) supportsSort(sort string) bool {
+	switch t.kind {
+	case kindUser, kindSearch:
+		return sort != "rising"
+	case kindComments:
+		return false
+	}
+	return true
+}
+
+// seenKey is the key that marks the item id as sent. Each kind of target
+// has its own key space. Subreddit posts keep the shared reddit:posts space
+// so a post is not sent again after upgrading, the other kinds are tracked
+// per subscription, comments also per chat thread as the same Reddit
+// thread can be followed from several chats.
+func (t target) seenKey(sub *models.Subscription, id string) string {
+	switch t.kind {
+	case kindSubreddit:
+		return "reddit:posts:" + id
+	case kindComments:
+		return fmt.Sprintf("reddit:comments:%d:%s:%s:%s", sub.ThreadId, strings.ToLower(sub.Name), t.postID, id)
+	}
+	return fmt.Sprintf("reddit:%s:%s:%s", t.kind, strings.ToLower(sub.Name), id)
+}
@@ -0,0 +1,154 @@
+package reddit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+	"github.com/camopy/rss_everything/db"
+	"github.com/camopy/rss_everything/zaplog"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		in   string
+		kind targetKind
+		name string
+	}{
+		{"golang", kindSubreddit, "/r/golang"},
+		{"r/golang", kindSubreddit, "/r/golang"},
+		{"/r/golang/", kindSubreddit, "/r/golang"},
+		{"https://old.reddit.com/r/golang", kindSubreddit, "/r/golang"},
+		{"r/golang+rust", kindMulti, "/r/golang+rust"},
+		{"/user/spez/m/langs", kindMulti, "/user/spez/m/langs"},
+		{"u/spez", kindUser, "/user/spez"},
+		{"/user/spez/submitted", kindUser, "/user/spez"},
+		{"/r/golang/search?q=go+generics", kindSearch, "/r/golang/search?q=go+generics"},
+		{"https://www.reddit.com/r/IAmA/comments/abc123/i_am/", kindComments, "/comments/abc123"},
+		{"https://redd.it/abc123", kindComments, "/comments/abc123"},
+		{"/comments/abc123", kindComments, "/comments/abc123"},
+	}
+	for _, tt := range tests {
+		target, err := parseTarget(tt.in)
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.kind, target.kind, tt.in)
+		assert.Equal(t, tt.name, target.String(), tt.in)
+	}
+
+	for _, in := range []string{"https://example.com/r/golang", "/r/golang/search", "u/spez/comments", "/r/golang/wiki"} {
+		_, err := parseTarget(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestParseCommandTargets(t *testing.T) {
+	r := &Reddit{}
+
+	c, err := r.ParseCommand(models.Command{Text: `add r/golang new query="go generics"`})
+	assert.NoError(t, err)
+	assert.Equal(t, "/r/golang/search?q=go+generics", c.SubName())
+	assert.Equal(t, "https://www.reddit.com/r/golang/search?q=go+generics", c.Url())
+
+	_, err = r.ParseCommand(models.Command{Text: "add u/spez rising"})
+	assert.ErrorContains(t, err, "cannot be sorted by rising")
+
+	_, err = r.ParseCommand(models.Command{Text: "add u/spez query=go"})
+	assert.ErrorContains(t, err, "only subreddits can be searched")
+
+	c, err = r.ParseCommand(models.Command{Text: "remove r/golang"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/r/golang", c.SubName())
+}
+
+func TestTargetListing(t *testing.T) {
+	sub := &models.Subscription{Name: "/r/golang/search?q=generics"}
+	target, q, err := subscription(sub)
+	assert.NoError(t, err)
+	path, params := target.listing(q)
+	assert.Equal(t, "/r/golang/search", path)
+	assert.Equal(t, map[string]string{"limit": "10", "sort": "new", "q": "generics", "restrict_sr": "1"}, params)
+	assert.Equal(t, "reddit:search:/r/golang/search?q=generics:a1", target.seenKey(sub, "a1"))
+
+	target, q, err = subscription(&models.Subscription{Name: "/user/spez"})
+	assert.NoError(t, err)
+	path, params = target.listing(q)
+	assert.Equal(t, "/user/spez/submitted", path)
+	assert.Equal(t, "hot", params["sort"])
+
+	sub = &models.Subscription{Name: "/r/golang"}
+	target, _, err = subscription(sub)
+	assert.NoError(t, err)
+	assert.Equal(t, "reddit:posts:a1", target.seenKey(sub, "a1"))
+}
+
+const testThread = `[
+{"kind":"Listing","data":{"children":[{"kind":"t3","data":{"id":"abc","title":"AMA","permalink":"/r/IAmA/comments/abc/ama/","created_utc":%[1]d.0}}]}},
+{"kind":"Listing","data":{"children":[%[2]s{"kind":"more","data":{"count":10}}]}}
+]`
+
+const testComment = `{"kind":"t1","data":{"id":"%[1]s","author":"someone","body":"Question %[1]s?","permalink":"/r/IAmA/comments/abc/ama/%[1]s/","score":3,"created_utc":%[2]d.0}},`
+
+func TestFetchComments(t *testing.T) {
+	comments := []string{"c1"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/comments/abc.json", r.URL.Path)
+		assert.Equal(t, "new", r.URL.Query().Get("sort"))
+		now := time.Now().Unix()
+		var children string
+		for _, id := range comments {
+			children += fmt.Sprintf(testComment, id, now)
+		}
+		_, _ = fmt.Fprintf(w, testThread, now, children)
+	}))
+	defer srv.Close()
+
+	c := newPublicClient()
+	c.baseUrl = srv.URL
+	c.interval = 0
+	r := New(zaplog.L(), db.NewMemory(), "", "", "", "").(*Reddit)
+	r.client = c
+
+	cmd, err := r.ParseCommand(models.Command{Text: "add https://www.reddit.com/r/IAmA/comments/abc/ama/"})
+	assert.NoError(t, err)
+	sub := &models.Subscription{Name: cmd.SubName(), Options: cmd.Options()}
+	ctx := context.Background()
+	assert.NoError(t, r.Validate(ctx, sub))
+
+	// the comments already in the thread are not sent
+	contents, err := r.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+
+	comments = append([]string{"c2"}, comments...)
+	contents, err = r.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 1)
+	assert.Contains(t, contents[0].Text, "💬 u/someone on AMA\nQuestion c2?")
+	assert.Contains(t, contents[0].Text, "https://www.reddit.com/r/IAmA/comments/abc/ama/c2/")
+
+	contents, err = r.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+
+	// a subscription to the thread from another chat is primed and tracked on
+	// its own
+	other := &models.Subscription{Name: sub.Name, ThreadId: 2, Options: cmd.Options()}
+	contents, err = r.Fetch(ctx, other)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+
+	comments = append([]string{"c3"}, comments...)
+	for _, s := range []*models.Subscription{sub, other} {
+		contents, err = r.Fetch(ctx, s)
+		assert.NoError(t, err)
+		assert.Len(t, contents, 1)
+		assert.Contains(t, contents[0].Text, "Question c3?")
+		assert.Equal(t, s.ThreadId, contents[0].ThreadId)
+	}
+}
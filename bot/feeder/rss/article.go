@@ -0,0 +1,241 @@
+package rss
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+const (
+	modeOption   = "mode"
+	lengthOption = "length"
+
+	modeTitle   = "title"
+	modeExcerpt = "excerpt"
+	modeArticle = "article"
+
+	defaultExcerptLength = 300
+	defaultArticleLength = 1500
+	// maxTextLength keeps messages under the Telegram limit of 4096 characters,
+	// Discord splits them in messages of 2000.
+	maxTextLength = 3500
+)
+
+var (
+	positiveClassRe = regexp.MustCompile(`(?i)article|body|content|entry|main|page|post|text|blog|story`)
+	negativeClassRe = regexp.MustCompile(`(?i)comment|meta|footer|footnote|sidebar|share|social|related|nav|menu|promo|banner|sponsor|\bads?\b|advert|popup|cookie|subscribe|newsletter`)
+	spacesRe        = regexp.MustCompile(`[ \t\r\f\v]+`)
+	newlinesRe      = regexp.MustCompile(`\n{3,}`)
+)
+
+// contentMode returns the mode and length of the text included with each
+// item of the subscription.
+func contentMode(sub *models.Subscription) (mode string, length int) {
+	mode = sub.Options[modeOption]
+	switch mode {
+	case modeExcerpt:
+		length = defaultExcerptLength
+	case modeArticle:
+		length = defaultArticleLength
+	default:
+		return modeTitle, 0
+	}
+	if n, err := strconv.Atoi(sub.Options[lengthOption]); err == nil && n > 0 {
+		length = min(n, maxTextLength)
+	}
+	return mode, length
+}
+
+func validateContentMode(p models.ParsedCommand) error {
+	switch p.Get(modeOption) {
+	case "", modeTitle, modeExcerpt, modeArticle:
+	default:
+		return fmt.Errorf("invalid %s %q, use %s, %s or %s", modeOption, p.Get(modeOption), modeTitle, modeExcerpt, modeArticle)
+	}
+	if p.Has(lengthOption) {
+		if n, err := strconv.Atoi(p.Get(lengthOption)); err != nil || n <= 0 {
+			return fmt.Errorf("invalid %s %q", lengthOption, p.Get(lengthOption))
+		}
+	}
+	return nil
+}
+
+// itemText returns the text to send with a new item according to the
+// content mode of the subscription. Article extraction falls back to the
+// excerpt of the feed when the page cannot be fetched.
+func (u *RSS) itemText(ctx context.Context, sub *models.Subscription, item *gofeed.Item) string {
+	mode, length := contentMode(sub)
+	switch mode {
+	case modeArticle:
+		if item.Link != "" {
+			text, err := u.fetchArticle(ctx, sub, item.Link)
+			if err == nil && text != "" {
+				return truncate(text, length)
+			}
+		}
+		fallthrough
+	case modeExcerpt:
+		content := item.Content
+		if item.Description != "" && (mode == modeExcerpt || content == "") {
+			content = item.Description
+		}
+		return truncate(htmlToText(content), length)
+	}
+	return ""
+}
+
+func (u *RSS) fetchArticle(ctx context.Context, sub *models.Subscription, link string) (string, error) {
+	body, _, _, err := u.download(ctx, sub, link)
+	if err != nil {
+		return "", err
+	}
+	return extractArticle(body)
+}
+
+// extractArticle finds the main text of a page with a readability-like
+// heuristic: paragraphs are grouped by parent, each parent scored by the
+// amount of text it holds and by its class and id, and the best one wins.
+func extractArticle(page []byte) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return "", err
+	}
+	doc.Find("script, style, noscript, nav, header, footer, aside, form, iframe, svg, figure").Remove()
+	doc.Find("*").Each(func(_ int, s *goquery.Selection) {
+		if negativeClassRe.MatchString(s.AttrOr("class", "") + " " + s.AttrOr("id", "")) {
+			if !positiveClassRe.MatchString(s.AttrOr("class", "") + " " + s.AttrOr("id", "")) {
+				s.Remove()
+			}
+		}
+	})
+
+	scores := make(map[*html.Node]float64)
+	var order []*html.Node
+	addScore := func(n *html.Node, score float64) {
+		if n == nil || n.Type != html.ElementNode {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			order = append(order, n)
+			scores[n] = classWeight(goquery.NewDocumentFromNode(n).Selection)
+		}
+		scores[n] += score
+	}
+
+	doc.Find("p, pre, td, blockquote").Each(func(_ int, s *goquery.Selection) {
+		text := strings.TrimSpace(s.Text())
+		if utf8.RuneCountInString(text) < 25 {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + min(float64(utf8.RuneCountInString(text))/100, 3)
+		parent := s.Parent()
+		addScore(parent.Get(0), score)
+		addScore(parent.Parent().Get(0), score/2)
+	})
+
+	var best *html.Node
+	for _, n := range order {
+		if best == nil || scores[n] > scores[best] {
+			best = n
+		}
+	}
+	if best == nil {
+		return "", fmt.Errorf("no article found")
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, best); err != nil {
+		return "", err
+	}
+	return htmlToText(buf.String()), nil
+}
+
+func classWeight(s *goquery.Selection) float64 {
+	attrs := s.AttrOr("class", "") + " " + s.AttrOr("id", "")
+	var weight float64
+	if positiveClassRe.MatchString(attrs) {
+		weight += 25
+	}
+	if negativeClassRe.MatchString(attrs) {
+		weight -= 25
+	}
+	if goquery.NodeName(s) == "article" || goquery.NodeName(s) == "main" {
+		weight += 25
+	}
+	return weight
+}
+
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "ul": true, "ol": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "pre": true, "tr": true, "table": true, "section": true,
+	"article": true, "hr": true,
+}
+
+// htmlToText converts an html fragment to plain text, keeping paragraphs on
+// their own lines.
+func htmlToText(s string) string {
+	if !strings.Contains(s, "<") {
+		return strings.TrimSpace(spacesRe.ReplaceAllString(html.UnescapeString(s), " "))
+	}
+	nodes, err := html.ParseFragment(strings.NewReader(s), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return ""
+	}
+
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(n.Data)
+		case html.ElementNode:
+			if n.Data == "script" || n.Data == "style" {
+				return
+			}
+			if blockElements[n.Data] {
+				b.WriteString("\n")
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+			if blockElements[n.Data] {
+				b.WriteString("\n")
+			}
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+
+	lines := strings.Split(b.String(), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(spacesRe.ReplaceAllString(lines[i], " "))
+	}
+	text := strings.Join(lines, "\n")
+	return strings.TrimSpace(newlinesRe.ReplaceAllString(text, "\n\n"))
+}
+
+// truncate shortens s to at most n runes, cutting at a word boundary.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)[:n]
+	cut := string(runes)
+	if i := strings.LastIndexAny(cut, " \n"); i > n/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimSpace(cut) + "…"
+}
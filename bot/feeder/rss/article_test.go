@@ -0,0 +1,85 @@
+package rss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+const testArticlePage = `<html><head><title>Post</title><script>var x = 1;</script></head>
+<body>
+  <nav class="menu"><a href="/">Home</a> <a href="/about">About us and everything else</a></nav>
+  <div class="sidebar"><p>Subscribe to our newsletter to receive all the updates, offers and news.</p></div>
+  <div id="main">
+    <div class="post-content">
+      <h1>The title</h1>
+      <p>The first paragraph of the article is long enough, with commas, clauses, and details.</p>
+      <p>The second paragraph continues the story with &amp; even more relevant information.</p>
+    </div>
+  </div>
+  <div class="comments"><p>Great post, thanks for sharing, it was really helpful to me!</p></div>
+</body></html>`
+
+func TestExtractArticle(t *testing.T) {
+	text, err := extractArticle([]byte(testArticlePage))
+	assert.NoError(t, err)
+	assert.Equal(t, `The title
+
+The first paragraph of the article is long enough, with commas, clauses, and details.
+
+The second paragraph continues the story with & even more relevant information.`, text)
+}
+
+func TestHtmlToText(t *testing.T) {
+	assert.Equal(t, "Hello world\n\nSecond", htmlToText("<p>Hello <b>world</b></p><p>Second</p><script>x()</script>"))
+	assert.Equal(t, "a & b", htmlToText("a &amp;   b"))
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", truncate("short", 10))
+	assert.Equal(t, "hello wonderful…", truncate("hello wonderful world", 17))
+	assert.Equal(t, "ããã…", truncate("ããããããã", 3))
+}
+
+func TestItemText(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(testArticlePage))
+	}))
+	defer srv.Close()
+
+	u := newTestRSS()
+	item := &gofeed.Item{
+		Link:        srv.URL + "/post",
+		Description: "<p>A <i>short</i> description of the post that is a bit long</p>",
+	}
+
+	t.Run("title only", func(t *testing.T) {
+		assert.Empty(t, u.itemText(context.Background(), &models.Subscription{}, item))
+	})
+
+	t.Run("excerpt", func(t *testing.T) {
+		sub := &models.Subscription{Options: map[string]string{modeOption: modeExcerpt, lengthOption: "30"}}
+		assert.Equal(t, "A short description of the…", u.itemText(context.Background(), sub, item))
+	})
+
+	t.Run("article", func(t *testing.T) {
+		sub := &models.Subscription{Options: map[string]string{modeOption: modeArticle}}
+		text := u.itemText(context.Background(), sub, item)
+		assert.True(t, strings.HasPrefix(text, "The title"))
+		assert.NotContains(t, text, "newsletter")
+	})
+
+	t.Run("article falls back to excerpt", func(t *testing.T) {
+		sub := &models.Subscription{Options: map[string]string{modeOption: modeArticle}}
+		broken := &gofeed.Item{Link: srv.URL + "\x00", Description: "fallback"}
+		assert.Equal(t, "fallback", u.itemText(context.Background(), sub, broken))
+	})
+}
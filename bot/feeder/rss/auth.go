@@ -0,0 +1,264 @@
+package rss
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+const (
+	userOption      = "user"
+	passwordOption  = "password"
+	tokenOption     = "token"
+	cookieOption    = "cookie"
+	headerOption    = "header"
+	userAgentOption = "user_agent"
+	// credentialsOption holds the encrypted secret options of a subscription.
+	credentialsOption = "credentials"
+	// maxRedirects is the redirect limit of net/http.
+	maxRedirects = 10
+)
+
+// subscriptionKey is the request context key of the subscription being
+// fetched.
+type subscriptionKey struct{}
+
+// secretOptions are never stored in plain text, they are moved to the
+// encrypted credentials option when the subscription is added.
+var secretOptions = []string{userOption, passwordOption, tokenOption, cookieOption, headerOption}
+
+// credentials are sent with every request to the host of a feed.
+type credentials struct {
+	User     string            `json:"user,omitempty"`
+	Password string            `json:"password,omitempty"`
+	Token    string            `json:"token,omitempty"`
+	Cookie   string            `json:"cookie,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+}
+
+func (c credentials) isEmpty() bool {
+	return c.User == "" && c.Password == "" && c.Token == "" && c.Cookie == "" && len(c.Headers) == 0
+}
+
+func validateAuthOptions(p models.ParsedCommand) error {
+	if p.Has(passwordOption) && !p.Has(userOption) {
+		return fmt.Errorf("%s requires %s", passwordOption, userOption)
+	}
+	if p.Has(userOption) && p.Has(tokenOption) {
+		return fmt.Errorf("use either %s or %s", userOption, tokenOption)
+	}
+	if p.Has(headerOption) {
+		if _, err := parseHeaders(p.Get(headerOption)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseHeaders parses "Name: value" headers separated by semicolons.
+func parseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, h := range strings.Split(s, ";") {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(h, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid %s %q, use \"Name: value; Other: value\"", headerOption, strings.TrimSpace(h))
+		}
+		headers[http.CanonicalHeaderKey(name)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// sealCredentials moves the secret options of sub, and the user info of its
+// url, to the encrypted credentials option.
+func (u *RSS) sealCredentials(sub *models.Subscription) error {
+	creds := credentials{
+		User:     sub.Options[userOption],
+		Password: sub.Options[passwordOption],
+		Token:    sub.Options[tokenOption],
+		Cookie:   sub.Options[cookieOption],
+	}
+	if sub.Options[headerOption] != "" {
+		headers, err := parseHeaders(sub.Options[headerOption])
+		if err != nil {
+			return err
+		}
+		creds.Headers = headers
+	}
+
+	feedUrl := sub.Url
+	if parsed, err := url.Parse(sub.Url); err == nil && parsed.User != nil {
+		if creds.User == "" {
+			creds.User = parsed.User.Username()
+			creds.Password, _ = parsed.User.Password()
+		}
+		parsed.User = nil
+		feedUrl = parsed.String()
+	}
+
+	if creds.isEmpty() {
+		return nil
+	}
+	if u.secrets == nil {
+		return fmt.Errorf("credentials cannot be stored, %w", u.noSecretsErr())
+	}
+	b, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	sealed, err := u.secrets.seal(b)
+	if err != nil {
+		return err
+	}
+
+	if sub.Options == nil {
+		sub.Options = make(map[string]string)
+	}
+	for _, name := range secretOptions {
+		delete(sub.Options, name)
+	}
+	sub.Options[credentialsOption] = sealed
+	sub.Url = feedUrl
+	return nil
+}
+
+func (u *RSS) subscriptionCredentials(sub *models.Subscription) (*credentials, error) {
+	sealed := sub.Options[credentialsOption]
+	if sealed == "" {
+		return nil, nil
+	}
+	if u.secrets == nil {
+		return nil, fmt.Errorf("rss: cannot read the credentials of %s, %w", sub.Name, u.noSecretsErr())
+	}
+	b, err := u.secrets.open(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("rss: cannot read the credentials of %s: %w", sub.Name, err)
+	}
+	var creds credentials
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+// authorize sets the user agent and credentials of sub on req. Credentials
+// are only sent to the host of the feed, never to the pages it links to.
+func (u *RSS) authorize(req *http.Request, sub *models.Subscription) error {
+	if sub == nil {
+		return nil
+	}
+	if ua := sub.Options[userAgentOption]; ua != "" {
+		req.Header.Set("User-Agent", ua)
+	}
+	if feedUrl, err := url.Parse(sub.Url); err != nil || !strings.EqualFold(feedUrl.Host, req.URL.Host) {
+		return nil
+	}
+
+	creds, err := u.subscriptionCredentials(sub)
+	if err != nil || creds == nil {
+		return err
+	}
+	for name, value := range creds.Headers {
+		req.Header.Set(name, value)
+	}
+	switch {
+	case creds.User != "":
+		req.SetBasicAuth(creds.User, creds.Password)
+	case creds.Token != "":
+		req.Header.Set("Authorization", "Bearer "+creds.Token)
+	}
+	if creds.Cookie != "" {
+		req.Header.Set("Cookie", creds.Cookie)
+	}
+	return nil
+}
+
+// checkRedirect drops the custom credential headers of the subscription
+// when a feed redirects to another host. net/http already drops the
+// Authorization and Cookie headers.
+func (u *RSS) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	sub, _ := req.Context().Value(subscriptionKey{}).(*models.Subscription)
+	if sub == nil {
+		return nil
+	}
+	if feedUrl, err := url.Parse(sub.Url); err == nil && strings.EqualFold(feedUrl.Host, req.URL.Host) {
+		return nil
+	}
+	creds, err := u.subscriptionCredentials(sub)
+	if err != nil || creds == nil {
+		return err
+	}
+	for name := range creds.Headers {
+		req.Header.Del(name)
+	}
+	return nil
+}
+
+// noSecretsErr tells why credentials cannot be sealed or opened.
+func (u *RSS) noSecretsErr() error {
+	if u.secretsErr != nil {
+		return fmt.Errorf("RSS_SECRET_KEY is invalid: %w", u.secretsErr)
+	}
+	return fmt.Errorf("RSS_SECRET_KEY is not set")
+}
+
+// ValidateSecretKey checks that key can encrypt the credentials of the
+// subscriptions.
+func ValidateSecretKey(key string) error {
+	_, err := newSecretBox(key)
+	return err
+}
+
+// secretBox encrypts values with AES-GCM using a key derived from a
+// passphrase.
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+func newSecretBox(passphrase string) (*secretBox, error) {
+	key := sha256.Sum256([]byte(passphrase))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretBox{aead: aead}, nil
+}
+
+func (s *secretBox) seal(plaintext []byte) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(s.aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+func (s *secretBox) open(sealed string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("sealed value is too short")
+	}
+	nonce, ciphertext := b[:s.aead.NonceSize()], b[s.aead.NonceSize():]
+	return s.aead.Open(nil, nonce, ciphertext, nil)
+}
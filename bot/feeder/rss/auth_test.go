@@ -0,0 +1,149 @@
+package rss
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+func TestSecretBox(t *testing.T) {
+	box, err := newSecretBox("passphrase")
+	assert.NoError(t, err)
+
+	sealed, err := box.seal([]byte("secret"))
+	assert.NoError(t, err)
+	assert.NotContains(t, sealed, "secret")
+
+	plain, err := box.open(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(plain))
+
+	other, err := newSecretBox("other")
+	assert.NoError(t, err)
+	_, err = other.open(sealed)
+	assert.Error(t, err)
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := parseHeaders("x-api-key: abc; Accept-Language: en")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"X-Api-Key": "abc", "Accept-Language": "en"}, headers)
+
+	_, err = parseHeaders("no value")
+	assert.Error(t, err)
+}
+
+func TestAuthenticatedFetch(t *testing.T) {
+	var gotAuth, gotCookie, gotKey, gotAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotCookie = r.Header.Get("Cookie")
+		gotKey = r.Header.Get("X-Api-Key")
+		gotAgent = r.Header.Get("User-Agent")
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "jenkins" || password != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(testFeed))
+	}))
+	defer srv.Close()
+
+	u := newTestRSS()
+	sub := &models.Subscription{
+		Name: "jenkins",
+		Url:  strings.Replace(srv.URL, "http://", "http://jenkins:s3cret@", 1),
+		Options: map[string]string{
+			cookieOption:    "session=1",
+			headerOption:    "X-Api-Key: abc",
+			userAgentOption: "custom/1.0",
+		},
+	}
+
+	err := u.Validate(context.Background(), sub)
+	assert.ErrorContains(t, err, "RSS_SECRET_KEY")
+
+	u.secrets, err = newSecretBox("passphrase")
+	assert.NoError(t, err)
+	assert.NoError(t, u.Validate(context.Background(), sub))
+
+	assert.Equal(t, srv.URL, sub.Url)
+	assert.Equal(t, []string{credentialsOption, userAgentOption}, sortedKeys(sub.Options))
+	assert.NotContains(t, sub.Options[credentialsOption], "s3cret")
+
+	feed, err := u.fetchFeed(context.Background(), sub)
+	assert.NoError(t, err)
+	assert.Len(t, feed.Items, 1)
+	assert.True(t, strings.HasPrefix(gotAuth, "Basic "))
+	assert.Equal(t, "session=1", gotCookie)
+	assert.Equal(t, "abc", gotKey)
+	assert.Equal(t, "custom/1.0", gotAgent)
+
+	req, err := http.NewRequest(http.MethodGet, "https://elsewhere.example.com/article", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, u.authorize(req, sub))
+	assert.Empty(t, req.Header.Get("Authorization"))
+	assert.Empty(t, req.Header.Get("Cookie"))
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestRedirectDropsHeaders(t *testing.T) {
+	var gotKey, gotLanguage string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("X-Api-Key")
+		gotLanguage = r.Header.Get("Accept-Language")
+		_, _ = w.Write([]byte(testFeed))
+	}))
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/feed" {
+			http.Redirect(w, r, "/moved", http.StatusFound)
+			return
+		}
+		if r.Header.Get("X-Api-Key") != "abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, other.URL, http.StatusFound)
+	}))
+	defer srv.Close()
+
+	u := newTestRSS()
+	u.http = &http.Client{CheckRedirect: u.checkRedirect}
+	var err error
+	u.secrets, err = newSecretBox("passphrase")
+	assert.NoError(t, err)
+	sub := &models.Subscription{
+		Name:    "private",
+		Url:     srv.URL + "/feed",
+		Options: map[string]string{headerOption: "X-Api-Key: abc; Accept-Language: en"},
+	}
+
+	// The headers follow redirects on the feed host, not to other hosts.
+	assert.NoError(t, u.Validate(context.Background(), sub))
+	assert.Empty(t, gotKey)
+	assert.Empty(t, gotLanguage)
+}
+
+func TestInvalidSecretKey(t *testing.T) {
+	u := newTestRSS()
+	u.secretsErr = errors.New("bad key")
+	sub := &models.Subscription{Name: "private", Url: "https://example.com/feed", Options: map[string]string{tokenOption: "abc"}}
+	assert.ErrorContains(t, u.Validate(context.Background(), sub), "RSS_SECRET_KEY is invalid: bad key")
+}
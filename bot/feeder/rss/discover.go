@@ -0,0 +1,142 @@
+package rss
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"go.uber.org/zap"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+const maxFeedSize = 10 << 20
+
+var feedMimeTypes = []string{
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/feed+json",
+}
+
+// commonFeedPaths are tried when a page does not advertise its feeds.
+var commonFeedPaths = []string{
+	"/feed",
+	"/rss",
+	"/rss.xml",
+	"/atom.xml",
+	"/feed.xml",
+	"/index.xml",
+	"/feed.json",
+}
+
+// resolveFeedUrl returns the feed url for rawUrl. When rawUrl points to a
+// website instead of a feed, the feeds it advertises or that live at common
+// paths are returned as candidates.
+func (u *RSS) resolveFeedUrl(ctx context.Context, sub *models.Subscription, rawUrl string) (candidates []string, err error) {
+	body, pageUrl, contentType, err := u.download(ctx, sub, rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := u.client.Parse(bytes.NewReader(body)); err == nil {
+		return []string{rawUrl}, nil
+	}
+	if !isHTML(contentType, body) {
+		return nil, fmt.Errorf("%s is not a valid feed", rawUrl)
+	}
+
+	u.logger.Info("discovering feeds", zap.String("url", pageUrl.String()))
+	candidates, err = advertisedFeeds(pageUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		for _, path := range commonFeedPaths {
+			candidate := pageUrl.ResolveReference(&url.URL{Path: path}).String()
+			if u.isFeed(ctx, sub, candidate) {
+				candidates = append(candidates, candidate)
+				break
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no feed found on %s", rawUrl)
+	}
+	return candidates, nil
+}
+
+// download returns the body, the final url after redirects and the content
+// type of rawUrl.
+func (u *RSS) download(ctx context.Context, sub *models.Subscription, rawUrl string) ([]byte, *url.URL, string, error) {
+	resp, err := u.get(ctx, sub, rawUrl, nil)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("%s is not reachable: %w", rawUrl, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, "", fmt.Errorf("%s returned status %d", rawUrl, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return body, resp.Request.URL, resp.Header.Get("Content-Type"), nil
+}
+
+func (u *RSS) isFeed(ctx context.Context, sub *models.Subscription, rawUrl string) bool {
+	body, _, _, err := u.download(ctx, sub, rawUrl)
+	if err != nil {
+		return false
+	}
+	_, err = u.client.Parse(bytes.NewReader(body))
+	return err == nil
+}
+
+// advertisedFeeds returns the absolute urls of the
+// <link rel="alternate" type="application/rss+xml"> elements of a page.
+func advertisedFeeds(pageUrl *url.URL, body []byte) ([]string, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var feeds []string
+	seen := make(map[string]bool)
+	doc.Find(`link[rel~="alternate"]`).Each(func(_ int, s *goquery.Selection) {
+		typ := strings.ToLower(strings.TrimSpace(s.AttrOr("type", "")))
+		href := strings.TrimSpace(s.AttrOr("href", ""))
+		if href == "" || !isFeedMimeType(typ) {
+			return
+		}
+		ref, err := url.Parse(href)
+		if err != nil {
+			return
+		}
+		feed := pageUrl.ResolveReference(ref).String()
+		if !seen[feed] {
+			seen[feed] = true
+			feeds = append(feeds, feed)
+		}
+	})
+	return feeds, nil
+}
+
+func isFeedMimeType(typ string) bool {
+	for _, t := range feedMimeTypes {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
+
+func isHTML(contentType string, body []byte) bool {
+	if strings.Contains(strings.ToLower(contentType), "html") {
+		return true
+	}
+	return strings.HasPrefix(http.DetectContentType(body), "text/html")
+}
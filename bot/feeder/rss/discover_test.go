@@ -0,0 +1,73 @@
+package rss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+func TestValidateDiscoversFeed(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/advertised", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head>
+<link rel="alternate" type="application/rss+xml" href="/blog/feed.xml">
+<link rel="stylesheet" href="/style.css">
+</head><body>Blog</body></html>`))
+	})
+	mux.HandleFunc("/several", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head>
+<link rel="alternate" type="application/rss+xml" href="/blog/feed.xml">
+<link rel="alternate" type="application/atom+xml" href="/comments/atom.xml">
+</head></html>`))
+	})
+	mux.HandleFunc("/blog/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testFeed))
+	})
+	mux.HandleFunc("/atom.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testFeed))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><body>No feed links</body></html>`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	u := newTestRSS()
+
+	t.Run("feed url", func(t *testing.T) {
+		sub := &models.Subscription{Url: srv.URL + "/blog/feed.xml"}
+		assert.NoError(t, u.Validate(context.Background(), sub))
+		assert.Equal(t, srv.URL+"/blog/feed.xml", sub.Url)
+	})
+
+	t.Run("advertised feed", func(t *testing.T) {
+		sub := &models.Subscription{Url: srv.URL + "/advertised"}
+		assert.NoError(t, u.Validate(context.Background(), sub))
+		assert.Equal(t, srv.URL+"/blog/feed.xml", sub.Url)
+	})
+
+	t.Run("common path", func(t *testing.T) {
+		sub := &models.Subscription{Url: srv.URL + "/"}
+		assert.NoError(t, u.Validate(context.Background(), sub))
+		assert.Equal(t, srv.URL+"/atom.xml", sub.Url)
+	})
+
+	t.Run("several candidates", func(t *testing.T) {
+		sub := &models.Subscription{Url: srv.URL + "/several"}
+		err := u.Validate(context.Background(), sub)
+		assert.ErrorContains(t, err, srv.URL+"/blog/feed.xml")
+		assert.ErrorContains(t, err, srv.URL+"/comments/atom.xml")
+	})
+}
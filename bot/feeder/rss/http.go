@@ -0,0 +1,192 @@
+package rss
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"go.uber.org/zap"
+
+	"github.com/camopy/rss_everything/bot/models"
+	"github.com/camopy/rss_everything/metrics"
+)
+
+const (
+	rssUserAgent   = "rss_everything/1.0 (+https://github.com/camopy/rss_everything)"
+	rssHttpTimeout = 30 * time.Second
+	rssHttpTTL     = 30 * 24 * time.Hour
+	// maxCacheAge caps how long a Cache-Control or Retry-After header can
+	// postpone the next fetch of a feed.
+	maxCacheAge = 24 * time.Hour
+	// pushPollInterval is how often feeds delivered by a WebSub hub are still
+	// polled, in case the hub misses entries.
+	pushPollInterval = 24 * time.Hour
+)
+
+// httpCache keeps the validators and back-off of the last response of a feed.
+type httpCache struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	NotBefore    time.Time `json:"not_before,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at,omitempty"`
+	// Hub and Topic are the WebSub links advertised by the feed.
+	Hub   string `json:"hub,omitempty"`
+	Topic string `json:"topic,omitempty"`
+}
+
+// fetchFeed downloads and parses the feed of the subscription with a
+// conditional GET. It returns a nil feed when the feed was not modified or
+// the publisher asked us to come back later.
+func (u *RSS) fetchFeed(ctx context.Context, sub *models.Subscription) (*gofeed.Feed, error) {
+	cache, err := u.getHttpCache(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Before(cache.NotBefore) {
+		u.logger.Info("skipping fetch until", zap.String("feed", sub.Name), zap.Time("notBefore", cache.NotBefore))
+		return nil, nil
+	}
+	if u.isPushActive(sub) && time.Since(cache.FetchedAt) < pushPollInterval {
+		u.logger.Info("skipping fetch of pushed feed", zap.String("feed", sub.Name))
+		return nil, nil
+	}
+
+	resp, err := u.get(ctx, sub, sub.Url, cache)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	cache.NotBefore = notBefore(resp)
+	cache.FetchedAt = time.Now()
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		u.logger.Info("feed not modified", zap.String("feed", sub.Name))
+		u.subscribeHub(ctx, sub, cache.Hub, cache.Topic)
+		return nil, u.saveHttpCache(ctx, sub, cache)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		if err := u.saveHttpCache(ctx, sub, cache); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("rss: %s returned status %d", sub.Url, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, err
+	}
+	feed, err := u.client.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	cache.Hub, cache.Topic = feedHubLinks(body, resp.Header)
+	u.subscribeHub(ctx, sub, cache.Hub, cache.Topic)
+	cache.ETag = resp.Header.Get("ETag")
+	cache.LastModified = resp.Header.Get("Last-Modified")
+	return feed, u.saveHttpCache(ctx, sub, cache)
+}
+
+// get requests url with the user agent and credentials of sub, sending the
+// validators of cache when it is not nil.
+func (u *RSS) get(ctx context.Context, sub *models.Subscription, url string, cache *httpCache) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, subscriptionKey{}, sub), http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", rssUserAgent)
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/feed+json, application/xml;q=0.9, */*;q=0.8")
+	if err := u.authorize(req, sub); err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		if cache.ETag != "" {
+			req.Header.Set("If-None-Match", cache.ETag)
+		}
+		if cache.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cache.LastModified)
+		}
+	}
+
+	start := time.Now()
+	resp, err := u.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	metrics.TrackExternalRequest(http.MethodGet, resp.Request.URL.Host, resp.StatusCode, time.Since(start))
+	return resp, nil
+}
+
+// notBefore returns the earliest time the feed should be fetched again
+// according to the Retry-After and Cache-Control headers of resp.
+func notBefore(resp *http.Response) time.Time {
+	now := time.Now()
+	var wait time.Duration
+
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			wait = time.Duration(seconds) * time.Second
+		} else if t, err := http.ParseTime(v); err == nil {
+			wait = t.Sub(now)
+		}
+	} else if resp.StatusCode < 300 || resp.StatusCode == http.StatusNotModified {
+		wait = maxAge(resp.Header.Get("Cache-Control"))
+	}
+
+	if wait <= 0 {
+		return time.Time{}
+	}
+	return now.Add(min(wait, maxCacheAge))
+}
+
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if directive == "no-cache" || directive == "no-store" {
+			return 0
+		}
+		if v, ok := strings.CutPrefix(directive, "max-age="); ok {
+			seconds, err := strconv.Atoi(v)
+			if err != nil {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return 0
+}
+
+func (u *RSS) getHttpCache(ctx context.Context, sub *models.Subscription) (*httpCache, error) {
+	var cache httpCache
+	b, err := u.db.Get(ctx, httpCacheKey(sub))
+	if err != nil {
+		if u.db.IsErrNotFound(err) {
+			return &cache, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &cache); err != nil {
+		return nil, err
+	}
+	return &cache, nil
+}
+
+func (u *RSS) saveHttpCache(ctx context.Context, sub *models.Subscription, cache *httpCache) error {
+	value, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	return u.db.Set(ctx, httpCacheKey(sub), value, rssHttpTTL)
+}
+
+// httpCacheKey includes the url, so a subscription re-added under the same
+// name for another feed does not get the validators of the old one.
+func httpCacheKey(sub *models.Subscription) string {
+	return fmt.Sprintf("rss:%s:http:%s", sub.Name, sub.Url)
+}
@@ -0,0 +1,98 @@
+package rss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+	"github.com/camopy/rss_everything/db"
+	"github.com/camopy/rss_everything/zaplog"
+)
+
+const testFeed = `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>Hello</title><link>https://example.com/hello</link><guid>hello</guid></item>
+</channel></rss>`
+
+func newTestRSS() *RSS {
+	return &RSS{
+		client: gofeed.NewParser(),
+		http:   http.DefaultClient,
+		logger: zaplog.L(),
+		db:     db.NewMemory(),
+
+		postLocks: make(map[string]*sync.Mutex),
+	}
+}
+
+func TestFetchFeedConditionalGet(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		_, _ = w.Write([]byte(testFeed))
+	}))
+	defer srv.Close()
+
+	u := newTestRSS()
+	sub := &models.Subscription{Name: "test", Url: srv.URL}
+
+	feed, err := u.fetchFeed(context.Background(), sub)
+	assert.NoError(t, err)
+	assert.Len(t, feed.Items, 1)
+
+	feed, err = u.fetchFeed(context.Background(), sub)
+	assert.NoError(t, err)
+	assert.Nil(t, feed)
+	assert.Equal(t, 2, requests)
+
+	cache, err := u.getHttpCache(context.Background(), sub)
+	assert.NoError(t, err)
+	assert.Equal(t, `"v1"`, cache.ETag)
+	assert.Equal(t, "Mon, 02 Jan 2006 15:04:05 GMT", cache.LastModified)
+
+	// the same name for another feed starts without validators
+	other := &models.Subscription{Name: "test", Url: srv.URL + "/other"}
+	cache, err = u.getHttpCache(context.Background(), other)
+	assert.NoError(t, err)
+	assert.Empty(t, cache.ETag)
+}
+
+func TestFetchFeedBackOff(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	u := newTestRSS()
+	sub := &models.Subscription{Name: "test", Url: srv.URL}
+
+	_, err := u.fetchFeed(context.Background(), sub)
+	assert.Error(t, err)
+
+	feed, err := u.fetchFeed(context.Background(), sub)
+	assert.NoError(t, err)
+	assert.Nil(t, feed)
+	assert.Equal(t, 1, requests)
+}
+
+func TestMaxAge(t *testing.T) {
+	assert.Equal(t, 5*time.Minute, maxAge("public, max-age=300"))
+	assert.Equal(t, time.Duration(0), maxAge("no-cache, max-age=300"))
+	assert.Equal(t, time.Duration(0), maxAge(""))
+}
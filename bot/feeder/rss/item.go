@@ -0,0 +1,86 @@
+package rss
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+const (
+	maxAgeOption     = "max_age"
+	defaultMaxAge    = 24 * time.Hour
+	minPostTTL       = 7 * 24 * time.Hour
+	unlimitedPostTTL = 90 * 24 * time.Hour
+)
+
+// itemID identifies an item across polls: its GUID, else its link, else a
+// hash of its title and link.
+func itemID(item *gofeed.Item) string {
+	if id := strings.TrimSpace(item.GUID); id != "" {
+		return id
+	}
+	if link := strings.TrimSpace(item.Link); link != "" {
+		return link
+	}
+	sum := sha1.Sum([]byte(item.Title + "\n" + item.Link))
+	return hex.EncodeToString(sum[:])
+}
+
+// itemDate returns the published date of the item, else its updated date,
+// else firstSeen.
+func itemDate(item *gofeed.Item, firstSeen time.Time) time.Time {
+	if item.PublishedParsed != nil && !item.PublishedParsed.IsZero() {
+		return *item.PublishedParsed
+	}
+	if item.UpdatedParsed != nil && !item.UpdatedParsed.IsZero() {
+		return *item.UpdatedParsed
+	}
+	return firstSeen
+}
+
+func subscriptionMaxAge(sub *models.Subscription) time.Duration {
+	v, ok := sub.Options[maxAgeOption]
+	if !ok {
+		return defaultMaxAge
+	}
+	maxAge, err := parseAge(v)
+	if err != nil {
+		return defaultMaxAge
+	}
+	return maxAge
+}
+
+// parseAge parses a duration that may also be given in days, e.g. 7d.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	if s == "0" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// postTTL keeps seen posts at least as long as they can still pass the age
+// cutoff, so they are not sent twice.
+func postTTL(maxAge time.Duration) time.Duration {
+	if maxAge == 0 {
+		return unlimitedPostTTL
+	}
+	return max(minPostTTL, maxAge+24*time.Hour)
+}
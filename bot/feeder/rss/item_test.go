@@ -0,0 +1,77 @@
+package rss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+func TestItemID(t *testing.T) {
+	assert.Equal(t, "guid", itemID(&gofeed.Item{GUID: "guid", Link: "https://example.com/a"}))
+	assert.Equal(t, "https://example.com/a", itemID(&gofeed.Item{Link: "https://example.com/a"}))
+
+	a := itemID(&gofeed.Item{Title: "a"})
+	b := itemID(&gofeed.Item{Title: "b"})
+	assert.Len(t, a, 40)
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, a, itemID(&gofeed.Item{Title: "a"}))
+}
+
+func TestItemDate(t *testing.T) {
+	published := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	updated := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	firstSeen := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, published, itemDate(&gofeed.Item{PublishedParsed: &published, UpdatedParsed: &updated}, firstSeen))
+	assert.Equal(t, updated, itemDate(&gofeed.Item{UpdatedParsed: &updated}, firstSeen))
+	assert.Equal(t, firstSeen, itemDate(&gofeed.Item{}, firstSeen))
+}
+
+func TestSubscriptionMaxAge(t *testing.T) {
+	assert.Equal(t, defaultMaxAge, subscriptionMaxAge(&models.Subscription{}))
+	assert.Equal(t, 7*24*time.Hour, subscriptionMaxAge(&models.Subscription{Options: map[string]string{maxAgeOption: "7d"}}))
+	assert.Equal(t, 36*time.Hour, subscriptionMaxAge(&models.Subscription{Options: map[string]string{maxAgeOption: "36h"}}))
+	assert.Equal(t, time.Duration(0), subscriptionMaxAge(&models.Subscription{Options: map[string]string{maxAgeOption: "0"}}))
+
+	_, err := parseAge("soon")
+	assert.Error(t, err)
+}
+
+func TestUndatedItemSentOnce(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<rss version="2.0"><channel><title>Test</title>
+<item><title>Undated</title><link>https://example.com/undated</link></item>
+</channel></rss>`))
+	}))
+	defer srv.Close()
+
+	u := newTestRSS()
+	ctx := context.Background()
+	sub := &models.Subscription{Name: "test", Url: srv.URL}
+
+	posts, err := u.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Len(t, posts, 1)
+
+	// the seen key about to expire is refreshed while the item is listed
+	key := postKey(sub.Name, "https://example.com/undated")
+	seen, err := u.db.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.NoError(t, u.db.Set(ctx, key, seen, 50*time.Millisecond))
+
+	posts, err = u.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, posts)
+
+	time.Sleep(100 * time.Millisecond)
+	posts, err = u.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, posts)
+}
@@ -0,0 +1,100 @@
+package rss
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/camopy/rss_everything/bot/feeder"
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+var _ feeder.Importer = (*RSS)(nil)
+
+type opml struct {
+	XMLName xml.Name    `xml:"opml"`
+	Version string      `xml:"version,attr"`
+	Head    opmlHead    `xml:"head"`
+	Body    []opmlEntry `xml:"body>outline"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type opmlEntry struct {
+	Text     string      `xml:"text,attr"`
+	Title    string      `xml:"title,attr,omitempty"`
+	Type     string      `xml:"type,attr,omitempty"`
+	XMLUrl   string      `xml:"xmlUrl,attr,omitempty"`
+	HTMLUrl  string      `xml:"htmlUrl,attr,omitempty"`
+	Outlines []opmlEntry `xml:"outline"`
+}
+
+// Import reads the feeds of an OPML document. Nested outlines (folders) are
+// flattened.
+func (u *RSS) Import(data []byte) ([]models.Subscription, error) {
+	var doc opml
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	var subs []models.Subscription
+	var walk func(entries []opmlEntry)
+	walk = func(entries []opmlEntry) {
+		for _, e := range entries {
+			if e.XMLUrl != "" {
+				subs = append(subs, models.Subscription{
+					Name:     strings.TrimSpace(firstNonEmpty(e.Title, e.Text, e.XMLUrl)),
+					Platform: "rss",
+					Url:      e.XMLUrl,
+				})
+			}
+			walk(e.Outlines)
+		}
+	}
+	walk(doc.Body)
+
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("no feeds found")
+	}
+	return subs, nil
+}
+
+func (u *RSS) Export(subs []models.Subscription) (*models.File, error) {
+	doc := opml{
+		Version: "2.0",
+		Head: opmlHead{
+			Title:       "rss_everything subscriptions",
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, sub := range subs {
+		doc.Body = append(doc.Body, opmlEntry{
+			Text:   sub.Name,
+			Title:  sub.Name,
+			Type:   "rss",
+			XMLUrl: sub.Url,
+		})
+	}
+
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &models.File{
+		Name: "subscriptions.opml",
+		Data: append([]byte(xml.Header), b...),
+	}, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package rss
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+func TestOPML(t *testing.T) {
+	u := &RSS{}
+
+	t.Run("import nested outlines", func(t *testing.T) {
+		subs, err := u.Import([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.0">
+  <head><title>Reader export</title></head>
+  <body>
+    <outline text="Tech">
+      <outline text="Go Blog" title="The Go Blog" type="rss" xmlUrl="https://go.dev/blog/feed.atom"/>
+      <outline text="LWN" type="rss" xmlUrl="https://lwn.net/headlines/rss"/>
+    </outline>
+    <outline text="No feed here"/>
+  </body>
+</opml>`))
+		assert.NoError(t, err)
+		assert.Equal(t, []models.Subscription{
+			{Name: "The Go Blog", Platform: "rss", Url: "https://go.dev/blog/feed.atom"},
+			{Name: "LWN", Platform: "rss", Url: "https://lwn.net/headlines/rss"},
+		}, subs)
+	})
+
+	t.Run("import without feeds", func(t *testing.T) {
+		_, err := u.Import([]byte(`<opml version="2.0"><body></body></opml>`))
+		assert.Error(t, err)
+	})
+
+	t.Run("export round trip", func(t *testing.T) {
+		subs := []models.Subscription{
+			{Name: "My Feed", Platform: "rss", Url: "https://example.com/feed?a=1&b=2"},
+		}
+		file, err := u.Export(subs)
+		assert.NoError(t, err)
+		assert.Equal(t, "subscriptions.opml", file.Name)
+
+		imported, err := u.Import(file.Data)
+		assert.NoError(t, err)
+		assert.Equal(t, subs, imported)
+	})
+}
@@ -0,0 +1,200 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"go.uber.org/zap"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+const (
+	audioOption      = "audio"
+	maxAudioMbOption = "max_audio_mb"
+
+	// defaultMaxAudioMb fits the Discord attachment limit of 10 MB. Larger
+	// episodes, up to the Telegram bot upload limit of maxAudioMb, can only
+	// be uploaded to Telegram.
+	defaultMaxAudioMb = 10
+	maxAudioMb        = 50
+)
+
+// episode holds the podcast details of an item.
+type episode struct {
+	AudioUrl  string
+	AudioType string
+	Size      int64
+	Duration  time.Duration
+	Image     string
+	Author    string
+}
+
+// itemEpisode returns the podcast details of an item, or nil when it has no
+// audio enclosure.
+func itemEpisode(feed *gofeed.Feed, item *gofeed.Item) *episode {
+	var e *episode
+	for _, enc := range item.Enclosures {
+		if enc == nil || enc.URL == "" || !strings.HasPrefix(enc.Type, "audio/") {
+			continue
+		}
+		size, _ := strconv.ParseInt(enc.Length, 10, 64)
+		e = &episode{
+			AudioUrl:  enc.URL,
+			AudioType: enc.Type,
+			Size:      size,
+		}
+		break
+	}
+	if e == nil {
+		return nil
+	}
+
+	if item.ITunesExt != nil {
+		e.Duration = parseItunesDuration(item.ITunesExt.Duration)
+		e.Image = item.ITunesExt.Image
+		e.Author = item.ITunesExt.Author
+	}
+	if e.Image == "" && item.Image != nil {
+		e.Image = item.Image.URL
+	}
+	if e.Image == "" && feed.ITunesExt != nil {
+		e.Image = feed.ITunesExt.Image
+	}
+	if e.Image == "" && feed.Image != nil {
+		e.Image = feed.Image.URL
+	}
+	if e.Author == "" {
+		e.Author = feed.Title
+	}
+	return e
+}
+
+// parseItunesDuration parses "HH:MM:SS", "MM:SS" or a number of seconds.
+func parseItunesDuration(s string) time.Duration {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	var total int
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0
+		}
+		total = total*60 + n
+	}
+	return time.Duration(total) * time.Second
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := int(d.Hours())
+	m := int(d.Minutes()) % 60
+	s := int(d.Seconds()) % 60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+func (e *episode) String() string {
+	if e.Duration > 0 {
+		return fmt.Sprintf("🎧 %s\n%s", formatDuration(e.Duration), e.AudioUrl)
+	}
+	return fmt.Sprintf("🎧 %s", e.AudioUrl)
+}
+
+func validatePodcastOptions(p models.ParsedCommand) error {
+	if p.Has(audioOption) {
+		if _, err := strconv.ParseBool(p.Get(audioOption)); err != nil {
+			return fmt.Errorf("invalid %s %q, use true or false", audioOption, p.Get(audioOption))
+		}
+	}
+	if p.Has(maxAudioMbOption) {
+		if n, err := strconv.Atoi(p.Get(maxAudioMbOption)); err != nil || n <= 0 || n > maxAudioMb {
+			return fmt.Errorf("invalid %s %q, must be between 1 and %d", maxAudioMbOption, p.Get(maxAudioMbOption), maxAudioMb)
+		}
+	}
+	return nil
+}
+
+// audioLimit returns the maximum size of the episodes to upload, or zero when
+// episodes are only linked.
+func audioLimit(sub *models.Subscription) int64 {
+	if upload, _ := strconv.ParseBool(sub.Options[audioOption]); !upload {
+		return 0
+	}
+	mb := defaultMaxAudioMb
+	if n, err := strconv.Atoi(sub.Options[maxAudioMbOption]); err == nil && n > 0 {
+		mb = min(n, maxAudioMb)
+	}
+	return int64(mb) << 20
+}
+
+// episodeMedia returns the media to deliver with an episode: the audio when
+// uploading is enabled and it fits the size cap, else the cover image.
+func (u *RSS) episodeMedia(ctx context.Context, sub *models.Subscription, title string, e *episode) []models.Media {
+	if limit := audioLimit(sub); limit > 0 && e.Size <= limit {
+		file, err := u.downloadAudio(ctx, sub, e, limit)
+		if err == nil {
+			return []models.Media{
+				{
+					Type:      models.MediaAudio,
+					File:      file,
+					Title:     title,
+					Performer: e.Author,
+					Duration:  e.Duration,
+				},
+			}
+		}
+		u.logger.Warn("failed to download episode", zap.String("feed", sub.Name), zap.String("url", e.AudioUrl), zap.Error(err))
+	}
+	if e.Image != "" {
+		return []models.Media{
+			{
+				Type: models.MediaPhoto,
+				Url:  e.Image,
+			},
+		}
+	}
+	return nil
+}
+
+func (u *RSS) downloadAudio(ctx context.Context, sub *models.Subscription, e *episode, limit int64) (*models.File, error) {
+	resp, err := u.get(ctx, sub, e.AudioUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > limit {
+		return nil, fmt.Errorf("episode is larger than %d bytes", limit)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("episode is larger than %d bytes", limit)
+	}
+
+	name := path.Base(resp.Request.URL.Path)
+	if name == "" || name == "/" || name == "." {
+		name = "episode.mp3"
+	}
+	return &models.File{
+		Name: name,
+		Data: data,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package rss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+const testPodcast = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel>
+<title>Show</title>
+<itunes:image href="https://example.com/show.jpg"/>
+<item>
+<title>Episode 1</title>
+<guid>ep1</guid>
+<enclosure url="%s/ep1.mp3" length="11" type="audio/mpeg"/>
+<itunes:duration>1:02:03</itunes:duration>
+</item>
+</channel></rss>`
+
+func TestParseItunesDuration(t *testing.T) {
+	assert.Equal(t, time.Hour+2*time.Minute+3*time.Second, parseItunesDuration("1:02:03"))
+	assert.Equal(t, 2*time.Minute+3*time.Second, parseItunesDuration("02:03"))
+	assert.Equal(t, 90*time.Second, parseItunesDuration("90"))
+	assert.Equal(t, time.Duration(0), parseItunesDuration("soon"))
+	assert.Equal(t, "1:02:03", formatDuration(parseItunesDuration("1:02:03")))
+	assert.Equal(t, "2:03", formatDuration(parseItunesDuration("2:03")))
+}
+
+func TestEpisodeMedia(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("audio bytes"))
+	}))
+	defer srv.Close()
+
+	u := newTestRSS()
+	feed, err := u.client.ParseString(strings.ReplaceAll(testPodcast, "%s", srv.URL))
+	assert.NoError(t, err)
+
+	e := itemEpisode(feed, feed.Items[0])
+	assert.NotNil(t, e)
+	assert.Equal(t, srv.URL+"/ep1.mp3", e.AudioUrl)
+	assert.Equal(t, "https://example.com/show.jpg", e.Image)
+	assert.Equal(t, "Show", e.Author)
+	assert.Equal(t, time.Hour+2*time.Minute+3*time.Second, e.Duration)
+
+	media := u.episodeMedia(context.Background(), &models.Subscription{Name: "show"}, "Episode 1", e)
+	assert.Equal(t, []models.Media{{Type: models.MediaPhoto, Url: "https://example.com/show.jpg"}}, media)
+
+	sub := &models.Subscription{Name: "show", Options: map[string]string{audioOption: "true"}}
+	media = u.episodeMedia(context.Background(), sub, "Episode 1", e)
+	assert.Len(t, media, 1)
+	assert.Equal(t, models.MediaAudio, media[0].Type)
+	assert.Equal(t, "ep1.mp3", media[0].File.Name)
+	assert.Equal(t, "audio bytes", string(media[0].File.Data))
+	assert.Equal(t, "Show", media[0].Performer)
+}
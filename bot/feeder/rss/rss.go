@@ -4,8 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"strconv"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mmcdole/gofeed"
@@ -14,6 +15,9 @@ import (
 	"github.com/camopy/rss_everything/bot/feeder"
 	"github.com/camopy/rss_everything/bot/models"
 	"github.com/camopy/rss_everything/db"
+	ge "github.com/camopy/rss_everything/util/generics"
+	"github.com/camopy/rss_everything/util/psub"
+	"github.com/camopy/rss_everything/websub"
 	"github.com/camopy/rss_everything/zaplog"
 )
 
@@ -23,17 +27,53 @@ const (
 )
 
 type RSS struct {
-	client *gofeed.Parser
-	logger *zaplog.Logger
-	db     db.DB
+	client    *gofeed.Parser
+	http      *http.Client
+	logger    *zaplog.Logger
+	db        db.DB
+	publisher psub.Publisher[[]models.Content]
+	// secrets encrypts the credentials of subscriptions, it is nil when no
+	// key is configured or, with secretsErr, when the key is invalid.
+	secrets    *secretBox
+	secretsErr error
+
+	// push receives entries from WebSub hubs, it is nil when disabled.
+	push     *websub.Subscriber
+	pushMu   sync.Mutex
+	pushSubs map[string]*models.Subscription
+
+	// postsMu guards postLocks, which serialize newPosts per subscription
+	// so a pushed and a polled feed do not send the same post twice.
+	postsMu   sync.Mutex
+	postLocks map[string]*sync.Mutex
 }
 
-func New(logger *zaplog.Logger, db db.DB) feeder.Feeder {
-	return &RSS{
-		client: gofeed.NewParser(),
-		logger: logger,
-		db:     db,
+func New(
+	logger *zaplog.Logger,
+	db db.DB,
+	publisher psub.Publisher[[]models.Content],
+	push *websub.Subscriber,
+	secretKey string,
+) feeder.Feeder {
+	u := &RSS{
+		client:    gofeed.NewParser(),
+		logger:    logger,
+		db:        db,
+		publisher: publisher,
+		push:      push,
+		pushSubs:  make(map[string]*models.Subscription),
+		postLocks: make(map[string]*sync.Mutex),
 	}
+	u.http = &http.Client{Timeout: rssHttpTimeout, CheckRedirect: u.checkRedirect}
+	if push != nil {
+		push.SetHandler(u.handlePush)
+	}
+	if secretKey != "" {
+		if u.secrets, u.secretsErr = newSecretBox(secretKey); u.secretsErr != nil {
+			logger.Error("rss credentials disabled, invalid RSS_SECRET_KEY", zap.Error(u.secretsErr))
+		}
+	}
+	return u
 }
 
 func (u *RSS) Name() string {
@@ -50,6 +90,7 @@ type rssCommand struct {
 	feedTitle string
 	interval  time.Duration
 	url       string
+	options   map[string]string
 }
 
 func (r rssCommand) Action() string {
@@ -76,55 +117,162 @@ func (r rssCommand) Url() string {
 	return r.url
 }
 
-func (u *RSS) ParseCommand(cmd models.Command) (models.Commander, error) {
-	// /rss operation[add|remove|list] feed_title interval[m] url
-	s := strings.Split(cmd.Text, " ")
+func (r rssCommand) Options() map[string]string {
+	return r.options
+}
 
-	c := &rssCommand{
-		threadId: cmd.ThreadId,
-		action:   s[0],
+func (u *RSS) Command() models.CommandSpec {
+	return models.CommandSpec{
+		Name:        "rss",
+		Description: "RSS, Atom and JSON feeds",
+		Actions: []models.ActionSpec{
+			{
+				Name:    "add",
+				Aliases: []string{"new", "subscribe"},
+				Help:    "subscribe to a feed",
+				Args: []models.ArgSpec{
+					{Name: "title", Help: "unique name of the subscription", Required: true},
+					{Name: "interval", Help: "polling interval in minutes (min 60)"},
+					{Name: "url", Help: "feed url", Required: true},
+				},
+				Flags: []models.ArgSpec{
+					{Name: maxAgeOption, Help: "skip items older than this, e.g. 48h or 7d, 0 to send all (default 1d)"},
+					{Name: modeOption, Help: "title (default), excerpt of the description or article extracted from the linked page"},
+					{Name: lengthOption, Help: "maximum characters of the excerpt or article"},
+					{Name: audioOption, Help: "true to upload podcast episodes instead of linking them"},
+					{Name: maxAudioMbOption, Help: fmt.Sprintf("largest episode to upload in MB (default %d)", defaultMaxAudioMb)},
+					{Name: userOption, Help: "basic auth user, stored encrypted", Secret: true},
+					{Name: passwordOption, Help: "basic auth password, stored encrypted", Secret: true},
+					{Name: tokenOption, Help: "bearer token, stored encrypted", Secret: true},
+					{Name: cookieOption, Help: "cookie header, stored encrypted", Secret: true},
+					{Name: headerOption, Help: `extra headers as "Name: value; Other: value", stored encrypted`, Secret: true},
+					{Name: userAgentOption, Help: "User-Agent sent when fetching the feed"},
+				},
+			},
+			{
+				Name:    "remove",
+				Aliases: []string{"rm", "delete", "unsubscribe"},
+				Help:    "remove a subscription",
+				Args: []models.ArgSpec{
+					{Name: "title", Required: true},
+				},
+			},
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Help:    "list subscriptions",
+			},
+			{
+				Name: "import",
+				Help: "subscribe to every feed of an attached OPML file",
+				Args: []models.ArgSpec{
+					{Name: "interval", Help: "polling interval in minutes (min 60)"},
+				},
+			},
+			{
+				Name: "export",
+				Help: "reply with an OPML file of all feeds",
+			},
+		},
 	}
+}
 
-	if len(s) > 1 {
-		c.feedTitle = s[1]
-
-		if len(s) > 2 {
-			interval, err := strconv.Atoi(s[2])
-			if err != nil {
-				return nil, fmt.Errorf("rss: invalid interval")
-			}
-			c.interval = time.Duration(interval) * time.Minute
+func (u *RSS) ParseCommand(cmd models.Command) (models.Commander, error) {
+	p, err := u.Command().Parse(cmd.Text)
+	if err != nil {
+		return nil, err
+	}
 
-			if c.interval.Minutes() < 60 {
-				return nil, models.ErrInvalidIntervalDuration
-			}
+	interval, err := p.Interval("interval")
+	if err != nil {
+		return nil, fmt.Errorf("rss: %w", err)
+	}
 
-			if len(s) > 3 {
-				c.url = s[3]
-			}
+	if p.Has(maxAgeOption) {
+		if _, err := parseAge(p.Get(maxAgeOption)); err != nil {
+			return nil, fmt.Errorf("rss: invalid %s: %w", maxAgeOption, err)
 		}
 	}
+	if err := validateContentMode(p); err != nil {
+		return nil, fmt.Errorf("rss: %w", err)
+	}
+	if err := validatePodcastOptions(p); err != nil {
+		return nil, fmt.Errorf("rss: %w", err)
+	}
+	if err := validateAuthOptions(p); err != nil {
+		return nil, fmt.Errorf("rss: %w", err)
+	}
 
-	return c, nil
+	return &rssCommand{
+		threadId:  cmd.ThreadId,
+		action:    p.Action,
+		feedTitle: p.Get("title"),
+		interval:  interval,
+		url:       p.Get("url"),
+		options: p.Options(
+			maxAgeOption, modeOption, lengthOption, audioOption, maxAudioMbOption,
+			userOption, passwordOption, tokenOption, cookieOption, headerOption, userAgentOption,
+		),
+	}, nil
+}
+
+// Validate checks that the subscription url is a feed. When it points to a
+// website, the url is replaced by the single feed discovered on it, or the
+// candidate feeds are returned in the error. Credentials given as options
+// are encrypted before the subscription is saved.
+func (u *RSS) Validate(ctx context.Context, sub *models.Subscription) error {
+	if sub.Url == "" {
+		return fmt.Errorf("rss: url is required")
+	}
+	if err := u.sealCredentials(sub); err != nil {
+		return fmt.Errorf("rss: %w", err)
+	}
+	candidates, err := u.resolveFeedUrl(ctx, sub, sub.Url)
+	if err != nil {
+		return fmt.Errorf("rss: %w", err)
+	}
+	if len(candidates) > 1 {
+		return fmt.Errorf("rss: found %d feeds on %s, add one of them:\n%s", len(candidates), sub.Url, strings.Join(candidates, "\n"))
+	}
+	if candidates[0] != sub.Url {
+		if !u.isFeed(ctx, sub, candidates[0]) {
+			return fmt.Errorf("rss: %s advertises %s, which is not a valid feed", sub.Url, candidates[0])
+		}
+		u.logger.Info("discovered feed", zap.String("url", sub.Url), zap.String("feed", candidates[0]))
+		sub.Url = candidates[0]
+	}
+	return nil
 }
 
 func (u *RSS) Fetch(ctx context.Context, sub *models.Subscription) ([]models.Content, error) {
 	u.logger.Info("fetching", zap.String("url", sub.Name))
-	feed, err := u.client.ParseURLWithContext(sub.Url, ctx)
-	if err != nil {
+	feed, err := u.fetchFeed(ctx, sub)
+	if err != nil || feed == nil {
 		return nil, err
 	}
+	return u.newPosts(ctx, sub, feed)
+}
+
+// newPosts returns the items of feed that were not sent yet and marks them
+// as seen.
+func (u *RSS) newPosts(ctx context.Context, sub *models.Subscription, feed *gofeed.Feed) ([]models.Content, error) {
+	lock := u.postLock(sub)
+	lock.Lock()
+	defer lock.Unlock()
+
+	maxAge := subscriptionMaxAge(sub)
+	now := time.Now()
 	posts := make([]models.Content, 0, rssFetchLimit)
 	for _, post := range feed.Items {
 		p := rssPost{
 			FeedTitle:  sub.Name,
-			ID:         post.GUID,
+			ID:         itemID(post),
 			Title:      post.Title,
-			CreatedUTC: uint64(post.PublishedParsed.UTC().UnixMilli()),
+			CreatedUTC: uint64(itemDate(post, now).UTC().UnixMilli()),
 			Permalink:  post.Link,
 		}
 
-		isNewPost, err := u.isNewPost(ctx, p)
+		isNewPost, err := u.isNewPost(ctx, p, maxAge)
 		if err != nil {
 			return nil, err
 		}
@@ -132,51 +280,74 @@ func (u *RSS) Fetch(ctx context.Context, sub *models.Subscription) ([]models.Con
 			continue
 		}
 
-		if err := u.savePost(ctx, p); err != nil {
+		if err := u.savePost(ctx, p, maxAge); err != nil {
 			return nil, err
 		}
 
 		u.logger.Info("saved new post", zap.String("post", p.Title))
+		p.Excerpt = u.itemText(ctx, sub, post)
+		p.Episode = itemEpisode(feed, post)
 
-		posts = append(posts, models.Content{
+		content := models.Content{
 			ThreadId: sub.ThreadId,
 			Text:     p.String(),
-		})
+		}
+		if p.Episode != nil {
+			content.Media = u.episodeMedia(ctx, sub, p.Title, p.Episode)
+		}
+		posts = append(posts, content)
 	}
 
 	return posts, nil
 }
 
-func (u *RSS) isNewPost(ctx context.Context, post rssPost) (bool, error) {
-	isDuplicatePost, err := u.isDuplicatePost(ctx, post.FeedTitle, post.ID)
-	if err != nil || isDuplicatePost {
-		return false, err
+func (u *RSS) postLock(sub *models.Subscription) *sync.Mutex {
+	key := ge.DefaultIfZero(sub.Id, strings.ToLower(sub.Name))
+	u.postsMu.Lock()
+	defer u.postsMu.Unlock()
+	lock, ok := u.postLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		u.postLocks[key] = lock
 	}
-	return !post.isOlderThanADay(), nil
+	return lock
 }
 
-func (u *RSS) isDuplicatePost(ctx context.Context, feedId, id string) (bool, error) {
-	s, err := u.db.Get(ctx, fmt.Sprintf("rss:%s:posts:%s", feedId, id))
+// isNewPost reports whether the post was not sent yet and is recent enough.
+// The seen key of a post is refreshed while it is in the feed, so an undated
+// post, dated when it is read, is not sent again once the key would expire.
+func (u *RSS) isNewPost(ctx context.Context, post rssPost, maxAge time.Duration) (bool, error) {
+	key := postKey(post.FeedTitle, post.ID)
+	seen, err := u.db.Get(ctx, key)
 	if err != nil && !u.db.IsErrNotFound(err) {
 		return false, err
 	}
-	return s != nil, nil
+	if seen != nil {
+		return false, u.db.Set(ctx, key, seen, postTTL(maxAge))
+	}
+	return !post.isOlderThan(maxAge), nil
 }
 
-func (p *rssPost) isOlderThanADay() bool {
-	createdAt := time.UnixMilli(int64(p.CreatedUTC))
-	if createdAt.IsZero() {
-		return true
+func postKey(feedId, id string) string {
+	return fmt.Sprintf("rss:%s:posts:%s", feedId, id)
+}
+
+// isOlderThan reports whether the post was created more than maxAge ago. A
+// zero maxAge disables the cutoff.
+func (p *rssPost) isOlderThan(maxAge time.Duration) bool {
+	if maxAge == 0 {
+		return false
 	}
-	return time.Now().Sub(createdAt) > 24*time.Hour
+	createdAt := time.UnixMilli(int64(p.CreatedUTC))
+	return time.Now().Sub(createdAt) > maxAge
 }
 
-func (u *RSS) savePost(ctx context.Context, post rssPost) error {
+func (u *RSS) savePost(ctx context.Context, post rssPost, maxAge time.Duration) error {
 	value, err := json.Marshal(post)
 	if err != nil {
 		return err
 	}
-	return u.db.Set(ctx, fmt.Sprintf("rss:%s:posts:%s", post.FeedTitle, post.ID), value, 7*24*time.Hour)
+	return u.db.Set(ctx, postKey(post.FeedTitle, post.ID), value, postTTL(maxAge))
 }
 
 type rssPost struct {
@@ -185,10 +356,23 @@ type rssPost struct {
 	Title      string
 	Permalink  string
 	CreatedUTC uint64
+	Excerpt    string   `json:"-"`
+	Episode    *episode `json:"-"`
 }
 
 func (p *rssPost) String() string {
 	createdAt := time.UnixMilli(int64(p.CreatedUTC))
-	return fmt.Sprintf(`%s - %s
-%s`, p.Title, createdAt.Format(time.RFC822Z), p.Permalink)
+	header := fmt.Sprintf("%s - %s", p.Title, createdAt.Format(time.RFC822Z))
+	if p.Episode != nil {
+		header += "\n" + p.Episode.String()
+	}
+	if p.Excerpt != "" {
+		return fmt.Sprintf(`%s
+
+%s
+
+%s`, header, p.Excerpt, p.Permalink)
+	}
+	return fmt.Sprintf(`%s
+%s`, header, p.Permalink)
 }
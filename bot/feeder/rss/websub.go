@@ -0,0 +1,133 @@
+package rss
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/camopy/rss_everything/bot/models"
+	ge "github.com/camopy/rss_everything/util/generics"
+)
+
+// subscribeHub subscribes to the WebSub hub advertised by a feed, so new
+// entries are pushed instead of waiting for the next poll.
+func (u *RSS) subscribeHub(ctx context.Context, sub *models.Subscription, hub, self string) {
+	if u.push == nil || sub.Id == "" || hub == "" {
+		return
+	}
+	topic := ge.DefaultIfZero(self, sub.Url)
+
+	u.pushMu.Lock()
+	u.pushSubs[sub.Id] = sub
+	u.pushMu.Unlock()
+
+	if err := u.push.Subscribe(ctx, sub.Id, hub, topic); err != nil {
+		u.logger.Error("websub subscribe failed", zap.String("feed", sub.Name), zap.String("hub", hub), zap.Error(err))
+	}
+}
+
+// Remove unsubscribes from the hub of a removed subscription. Leases are
+// kept when the bot stops, so pushes resume on restart.
+func (u *RSS) Remove(ctx context.Context, sub *models.Subscription) error {
+	if u.push == nil {
+		return nil
+	}
+	u.pushMu.Lock()
+	delete(u.pushSubs, sub.Id)
+	u.pushMu.Unlock()
+	return u.push.Unsubscribe(ctx, sub.Id)
+}
+
+// handlePush runs content pushed by a hub through the same dedup and
+// publishing as polled content.
+func (u *RSS) handlePush(ctx context.Context, id string, body []byte) {
+	u.pushMu.Lock()
+	sub, ok := u.pushSubs[id]
+	u.pushMu.Unlock()
+	if !ok {
+		u.logger.Warn("websub content for unknown subscription", zap.String("id", id))
+		return
+	}
+
+	feed, err := u.client.Parse(bytes.NewReader(body))
+	if err != nil {
+		u.logger.Error("invalid websub content", zap.String("feed", sub.Name), zap.Error(err))
+		return
+	}
+	posts, err := u.newPosts(ctx, sub, feed)
+	if err != nil {
+		u.logger.Error("error processing websub content", zap.String("feed", sub.Name), zap.Error(err))
+		return
+	}
+	u.logger.Info("websub content received", zap.String("feed", sub.Name), zap.Int("new posts", len(posts)))
+	if len(posts) > 0 && u.publisher != nil {
+		_ = u.publisher.SendData(ctx, posts)
+	}
+}
+
+func (u *RSS) isPushActive(sub *models.Subscription) bool {
+	return u.push != nil && sub.Id != "" && u.push.IsActive(sub.Id)
+}
+
+// feedHubLinks returns the hub and self urls advertised by the Link header or
+// by <link rel="hub"> / <atom:link rel="hub"> elements of the feed.
+func feedHubLinks(body []byte, header http.Header) (hub, self string) {
+	for _, link := range header.Values("Link") {
+		for _, part := range strings.Split(link, ",") {
+			href, params, ok := strings.Cut(part, ";")
+			if !ok {
+				continue
+			}
+			href = strings.Trim(strings.TrimSpace(href), "<>")
+			switch linkRel(params) {
+			case "hub":
+				hub = ge.DefaultIfZero(hub, href)
+			case "self":
+				self = ge.DefaultIfZero(self, href)
+			}
+		}
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(body))
+	d.Strict = false
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok || el.Name.Local != "link" {
+			continue
+		}
+		var rel, href string
+		for _, attr := range el.Attr {
+			switch attr.Name.Local {
+			case "rel":
+				rel = attr.Value
+			case "href":
+				href = attr.Value
+			}
+		}
+		switch rel {
+		case "hub":
+			hub = ge.DefaultIfZero(hub, href)
+		case "self":
+			self = ge.DefaultIfZero(self, href)
+		}
+	}
+	return hub, self
+}
+
+func linkRel(params string) string {
+	for _, p := range strings.Split(params, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if ok && strings.EqualFold(k, "rel") {
+			return strings.Trim(v, `"`)
+		}
+	}
+	return ""
+}
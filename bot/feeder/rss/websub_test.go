@@ -0,0 +1,98 @@
+package rss
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+type testPublisher struct {
+	contents chan []models.Content
+}
+
+func (p *testPublisher) SendData(ctx context.Context, data []models.Content) error {
+	p.contents <- data
+	return nil
+}
+
+func (p *testPublisher) SendError(err error) {}
+
+func TestFeedHubLinks(t *testing.T) {
+	t.Run("atom links", func(t *testing.T) {
+		hub, self := feedHubLinks([]byte(`<?xml version="1.0"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <link rel="hub" href="https://hub.example.com/"/>
+  <link rel="self" href="https://example.com/atom.xml"/>
+  <link rel="alternate" href="https://example.com/"/>
+</feed>`), http.Header{})
+		assert.Equal(t, "https://hub.example.com/", hub)
+		assert.Equal(t, "https://example.com/atom.xml", self)
+	})
+
+	t.Run("rss atom:link", func(t *testing.T) {
+		hub, self := feedHubLinks([]byte(`<rss xmlns:atom="http://www.w3.org/2005/Atom"><channel>
+<atom:link rel="hub" href="https://pubsubhubbub.appspot.com/"/>
+</channel></rss>`), http.Header{})
+		assert.Equal(t, "https://pubsubhubbub.appspot.com/", hub)
+		assert.Empty(t, self)
+	})
+
+	t.Run("link header", func(t *testing.T) {
+		header := http.Header{}
+		header.Add("Link", `<https://hub.example.com/>; rel="hub", <https://example.com/feed>; rel="self"`)
+		hub, self := feedHubLinks([]byte(testFeed), header)
+		assert.Equal(t, "https://hub.example.com/", hub)
+		assert.Equal(t, "https://example.com/feed", self)
+	})
+}
+
+func TestHandlePush(t *testing.T) {
+	publisher := &testPublisher{contents: make(chan []models.Content, 1)}
+	u := newTestRSS()
+	u.publisher = publisher
+	u.pushSubs = map[string]*models.Subscription{
+		"sub-1": {Id: "sub-1", Name: "test", ThreadId: 7, Options: map[string]string{maxAgeOption: "0"}},
+	}
+
+	u.handlePush(context.Background(), "sub-1", []byte(testFeed))
+	contents := <-publisher.contents
+	assert.Len(t, contents, 1)
+	assert.Equal(t, 7, contents[0].ThreadId)
+	assert.Contains(t, contents[0].Text, "https://example.com/hello")
+
+	// Already seen entries are not published again.
+	u.handlePush(context.Background(), "sub-1", []byte(testFeed))
+	assert.Len(t, publisher.contents, 0)
+}
+
+func TestNewPostsConcurrent(t *testing.T) {
+	u := newTestRSS()
+	sub := &models.Subscription{Id: "sub-1", Name: "test", Options: map[string]string{maxAgeOption: "0"}}
+
+	feed, err := u.client.Parse(strings.NewReader(testFeed))
+	assert.NoError(t, err)
+
+	// A push and a poll of the same feed send each post once.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sent := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			posts, err := u.newPosts(context.Background(), sub, feed)
+			assert.NoError(t, err)
+			mu.Lock()
+			sent += len(posts)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, sent)
+}
@@ -0,0 +1,261 @@
+package scrapper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/gocolly/colly/v2/proxy"
+	"github.com/imroc/req/v3"
+	"go.uber.org/zap"
+
+	"github.com/camopy/rss_everything/db"
+	"github.com/camopy/rss_everything/zaplog"
+)
+
+const (
+	scrapperCookiesTable = "scrapper:cookies:"
+
+	// defaultDelay is waited between the requests to a domain.
+	defaultDelay = 2 * time.Second
+	// cookieTTL keeps the cookies of a site that is no longer scraped for a
+	// while, session cookies included.
+	cookieTTL = 7 * 24 * time.Hour
+)
+
+var proxySchemes = []string{"http", "https", "socks5"}
+
+// ClientConfig tunes the requests made to the scraped sites.
+type ClientConfig struct {
+	// Parallelism is the number of concurrent requests to a domain, 1 when
+	// zero.
+	Parallelism int
+	// Delay is waited between the requests to a domain, 2s when zero.
+	Delay time.Duration
+	// RespectRobotsTxt skips the pages disallowed by the robots.txt of the
+	// sites.
+	RespectRobotsTxt bool
+	// Proxies are http, https or socks5 proxy urls, used in turns.
+	Proxies []string
+}
+
+// Validate checks the proxies of the config, so a mistyped one fails at
+// startup instead of the scrapper running without proxies.
+func (c ClientConfig) Validate() error {
+	for i, p := range c.Proxies {
+		u, err := url.Parse(p)
+		if err != nil || u.Host == "" || !slices.Contains(proxySchemes, u.Scheme) {
+			// the url may hold credentials, only its position is given
+			return fmt.Errorf("scrapper: invalid proxy %d of %d, use %s urls", i+1, len(c.Proxies), strings.Join(proxySchemes, ", "))
+		}
+	}
+	return nil
+}
+
+// WithClientConfig sets the rate limits, robots.txt policy and proxies of
+// the scrapper requests.
+func WithClientConfig(cfg ClientConfig) Option {
+	return func(s *Scrapper) {
+		s.clientConfig = cfg
+	}
+}
+
+// client is shared by the fetches of all subscriptions, so the limits of a
+// domain hold across them and the cookies set by a site are sent back.
+type client struct {
+	logger *zaplog.Logger
+	base   *colly.Collector
+	req    *req.Client
+	jar    *cookieJar
+
+	parallelism int
+	delay       time.Duration
+
+	mu      sync.Mutex
+	limited map[string]bool
+}
+
+func newClient(logger *zaplog.Logger, db db.DB, cfg ClientConfig) (*client, error) {
+	cl := &client{
+		logger:      logger,
+		jar:         newCookieJar(db),
+		parallelism: max(cfg.Parallelism, 1),
+		delay:       cfg.Delay,
+		limited:     make(map[string]bool),
+	}
+	if cl.delay == 0 {
+		cl.delay = defaultDelay
+	}
+
+	cl.req = req.C().ImpersonateChrome().SetCookieJar(cl.jar)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if len(cfg.Proxies) > 0 {
+		switcher, err := proxy.RoundRobinProxySwitcher(cfg.Proxies...)
+		if err != nil {
+			return nil, fmt.Errorf("scrapper: invalid proxies: %w", err)
+		}
+		cl.req.SetProxy(switcher)
+	}
+
+	cl.base = colly.NewCollector(func(collector *colly.Collector) {
+		collector.UserAgent = cl.req.Headers.Get("user-agent")
+		collector.IgnoreRobotsTxt = !cfg.RespectRobotsTxt
+		// the visited urls are shared by the collectors of every fetch
+		collector.AllowURLRevisit = true
+	})
+	cl.base.SetClient(&http.Client{
+		Transport: cl.req.Transport,
+		Jar:       cl.jar,
+	})
+	return cl, nil
+}
+
+// collector returns a collector impersonating Chrome, bound to the limits,
+// cookies and proxies of the client.
+func (cl *client) collector(ctx context.Context) *colly.Collector {
+	c := cl.base.Clone()
+	c.Context = ctx
+	c.OnRequest(func(r *colly.Request) {
+		cl.limit(r.URL.Host)
+		cl.logger.Info("Visiting", zap.String("url", r.URL.String()))
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		cl.logger.Error("Something went wrong", zap.Error(err), zap.String("url", r.Request.URL.String()), zap.Int("status", r.StatusCode))
+	})
+	return c
+}
+
+// limit adds the limit rule of a domain the first time it is requested.
+func (cl *client) limit(host string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.limited[host] {
+		return
+	}
+	err := cl.base.Limit(&colly.LimitRule{
+		DomainRegexp: "^" + regexp.QuoteMeta(host) + "$",
+		Parallelism:  cl.parallelism,
+		Delay:        cl.delay,
+	})
+	if err != nil {
+		cl.logger.Error("failed to limit domain", zap.String("host", host), zap.Error(err))
+		return
+	}
+	cl.limited[host] = true
+}
+
+// cookieJar keeps the cookies set by the sites in the db, so sessions and
+// anti-bot tokens survive restarts. The cookies of a host are loaded before
+// its first request and saved after a fetch.
+type cookieJar struct {
+	*cookiejar.Jar
+	db db.DB
+
+	mu      sync.Mutex
+	loaded  map[string]bool
+	cookies map[string]map[string]*http.Cookie
+	dirty   map[string]bool
+}
+
+func newCookieJar(db db.DB) *cookieJar {
+	jar, _ := cookiejar.New(nil)
+	return &cookieJar{
+		Jar:     jar,
+		db:      db,
+		loaded:  make(map[string]bool),
+		cookies: make(map[string]map[string]*http.Cookie),
+		dirty:   make(map[string]bool),
+	}
+}
+
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	host := u.Host
+	if j.cookies[host] == nil {
+		j.cookies[host] = make(map[string]*http.Cookie)
+	}
+	for _, c := range cookies {
+		// a relative max age would restart when the cookie is restored
+		if c.MaxAge > 0 {
+			expires := *c
+			expires.Expires, expires.MaxAge = time.Now().Add(time.Duration(c.MaxAge)*time.Second), 0
+			c = &expires
+		}
+		j.cookies[host][c.Name+";"+c.Domain+";"+c.Path] = c
+	}
+	j.dirty[host] = true
+}
+
+// load restores the saved cookies of the host of pageUrl.
+func (j *cookieJar) load(ctx context.Context, pageUrl string) error {
+	u, err := url.Parse(pageUrl)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	loaded := j.loaded[u.Host]
+	j.loaded[u.Host] = true
+	j.mu.Unlock()
+	if loaded {
+		return nil
+	}
+
+	data, err := j.db.Get(ctx, scrapperCookiesTable+u.Host)
+	if err != nil {
+		if j.db.IsErrNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	var cookies []*http.Cookie
+	if err := json.Unmarshal(data, &cookies); err != nil {
+		return err
+	}
+	j.SetCookies(&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}, cookies)
+	return nil
+}
+
+// save stores the unexpired cookies of the hosts that set some since the
+// last save.
+func (j *cookieJar) save(ctx context.Context) error {
+	j.mu.Lock()
+	saved := make(map[string][]*http.Cookie, len(j.dirty))
+	now := time.Now()
+	for host := range j.dirty {
+		saved[host] = make([]*http.Cookie, 0, len(j.cookies[host]))
+		for key, c := range j.cookies[host] {
+			if c.MaxAge < 0 || !c.Expires.IsZero() && c.Expires.Before(now) {
+				delete(j.cookies[host], key)
+				continue
+			}
+			saved[host] = append(saved[host], c)
+		}
+	}
+	clear(j.dirty)
+	j.mu.Unlock()
+
+	for host, cookies := range saved {
+		data, err := json.Marshal(cookies)
+		if err != nil {
+			return err
+		}
+		if err := j.db.Set(ctx, scrapperCookiesTable+host, data, cookieTTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package scrapper
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/gocolly/colly/v2"
+	"go.uber.org/zap"
+
+	"github.com/camopy/rss_everything/bot/models"
+	ge "github.com/camopy/rss_everything/util/generics"
+)
+
+const (
+	pagesOption   = "pages"
+	detailsOption = "details"
+
+	maxPages = 10
+	// maxDetailVisits bounds the detail pages visited by a poll, the other
+	// items are left for the next ones.
+	maxDetailVisits = 20
+)
+
+// crawlOptions are the pagination and detail settings of a subscription.
+type crawlOptions struct {
+	pages   int
+	details bool
+}
+
+func subscriptionCrawl(sub *models.Subscription) crawlOptions {
+	c := crawlOptions{pages: 1}
+	if n, err := strconv.Atoi(sub.Options[pagesOption]); err == nil && n > 0 {
+		c.pages = min(n, maxPages)
+	}
+	c.details, _ = strconv.ParseBool(sub.Options[detailsOption])
+	return c
+}
+
+// parseCrawlOptions checks the pagination and detail flags of an add command.
+func parseCrawlOptions(opts map[string]string) error {
+	if v, ok := opts[pagesOption]; ok {
+		if n, err := strconv.Atoi(v); err != nil || n < 1 || n > maxPages {
+			return fmt.Errorf("invalid %s %q, use 1 to %d", pagesOption, v, maxPages)
+		}
+	}
+	if v, ok := opts[detailsOption]; ok {
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("invalid %s %q, use true or false", detailsOption, v)
+		}
+	}
+	return nil
+}
+
+// scrap reads the items of the search results, following up to pages pages
+// through the next page link or page parameter of the definition. Items
+// missing a required field are skipped. Only a failure of the first page is
+// an error, crawling stops at a failed or empty later page and a failed one
+// is noted in the result.
+func (u *Scrapper) scrap(c *colly.Collector, def *Definition, pageUrl string, pages int) (scrapResult, error) {
+	res := scrapResult{
+		items:   make([]item, 0, 10),
+		missing: make(map[string]int),
+	}
+	c.OnHTML(def.Item, func(e *colly.HTMLElement) {
+		res.matched++
+		it := def.extract(e)
+		if missing := def.missing(it); len(missing) > 0 {
+			for _, f := range missing {
+				res.missing[f]++
+			}
+			return
+		}
+		res.items = append(res.items, it)
+	})
+	if def.Empty != "" {
+		c.OnHTML(def.Empty, func(e *colly.HTMLElement) {
+			res.empty = true
+		})
+	}
+	var next string
+	if def.Next != "" {
+		c.OnHTML(def.Next, func(e *colly.HTMLElement) {
+			if next == "" {
+				next = e.Request.AbsoluteURL(e.Attr("href"))
+			}
+		})
+	}
+
+	for page := 1; page <= pages && pageUrl != ""; page++ {
+		matched := res.matched
+		next = ""
+		if err := c.Visit(pageUrl); err != nil {
+			u.logger.Warn("Error visiting", zap.Error(err), zap.String("url", pageUrl))
+			if page == 1 {
+				return scrapResult{}, fmt.Errorf("scrapper: %s: %w", pageUrl, err)
+			}
+			res.failed = true
+			break
+		}
+		if page > 1 && res.matched == matched {
+			break
+		}
+		pageUrl = ge.Cond(next != "", next, def.pageUrl(pageUrl, page+1))
+	}
+	return res, nil
+}
+
+// pageUrl returns the url of the given page when the definition has a page
+// parameter, empty otherwise.
+func (d *Definition) pageUrl(current string, page int) string {
+	if d.PageParam == "" {
+		return ""
+	}
+	u, err := url.Parse(current)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	q.Set(d.PageParam, strconv.Itoa(page))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// detailsVisitor reads the detail pages of items, up to limit of them.
+type detailsVisitor struct {
+	def   *Definition
+	c     *colly.Collector
+	limit int
+
+	visited int
+	current *item
+}
+
+// visit fills the item with the fields of its detail page. It reports false
+// when the limit of visits is reached. A page that cannot be read leaves the
+// item without details.
+func (v *detailsVisitor) visit(it *item) bool {
+	if v.visited >= v.limit {
+		return false
+	}
+	if v.visited == 0 {
+		v.c.OnHTML("html", func(e *colly.HTMLElement) {
+			if v.current != nil {
+				v.def.Detail.extract(e, v.current)
+			}
+		})
+	}
+	v.visited++
+	v.current = it
+	defer func() { v.current = nil }()
+	// a failed page is logged by the collector, the item is sent without
+	// details
+	_ = v.c.Visit(it.Link)
+	it.Detailed = true
+	return true
+}
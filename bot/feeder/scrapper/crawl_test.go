@@ -0,0 +1,128 @@
+package scrapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+	ge "github.com/camopy/rss_everything/util/generics"
+)
+
+const testCrawlDefinition = `{
+	"name": "crawl",
+	"item": "ul.results li",
+	"fields": {
+		"title": {"selector": "h2"},
+		"link": {"selector": "a", "attr": "href", "absolute": true},
+		"price": {"selector": ".price"}
+	},
+	"next": "a.next",
+	"detail": {
+		"fields": {
+			"area": {"selector": ".features .area", "regex": "(\\d+) m²"},
+			"bedrooms": {"selector": ".features .rooms", "regex": "(\\d+)"},
+			"condo_fee": {"selector": ".fees .condo"},
+			"photos": {"selector": ".gallery img", "attr": "src", "absolute": true}
+		}
+	}
+}`
+
+func TestCrawlPagesAndDetails(t *testing.T) {
+	var visits []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		visits = append(visits, r.URL.RequestURI())
+		w.Header().Set("Content-Type", "text/html")
+		switch {
+		case r.URL.Path == "/search":
+			page := r.URL.Query().Get("page")
+			if page == "" {
+				page = "1"
+			}
+			_, _ = fmt.Fprintf(w, `<html><body><ul class="results">
+				<li><a href="/homes/%[1]s"><h2>Casa %[1]s</h2></a><p class="price">R$ 500.000</p></li>
+				</ul><a class="next" href="/search?page=%[2]d">next</a></body></html>`, page, len(visits)+1)
+		case strings.HasPrefix(r.URL.Path, "/homes/"):
+			rooms := ge.Cond(r.URL.Path == "/homes/1", "1 quarto", "3 quartos")
+			_, _ = fmt.Fprintf(w, `<html><body><ul class="features"><li class="area">70 m²</li><li class="rooms">%s</li></ul>
+				<p class="fees"><span class="condo">R$ 650</span></p>
+				<div class="gallery"><img src="/img/a.jpg"><img src="/img/b.jpg"><img src="/img/a.jpg"></div></body></html>`, rooms)
+		}
+	}))
+	defer srv.Close()
+
+	s := newTestScrapper(t)
+	ctx := context.Background()
+	_, err := s.define(ctx, []byte(testCrawlDefinition))
+	assert.NoError(t, err)
+
+	c, err := s.ParseCommand(models.Command{Text: "add crawl houses " + srv.URL + "/search 60 pages=3 details=true min_bedrooms=2"})
+	assert.NoError(t, err)
+	sub := &models.Subscription{Name: c.SubName(), Platform: c.Platform(), Url: c.Url(), Options: c.Options()}
+
+	contents, err := s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/search", "/search?page=2", "/search?page=3", "/homes/1", "/homes/2", "/homes/3"}, visits)
+	assert.Len(t, contents, 2)
+	assert.Equal(t, "Casa 2\nR$ 500.000\narea: 70\nbedrooms: 3\ncondo fee: R$ 650\n\n"+srv.URL+"/homes/2", contents[0].Text)
+	assert.Equal(t, []models.Media{
+		{Type: models.MediaPhoto, Url: srv.URL + "/img/a.jpg"},
+		{Type: models.MediaPhoto, Url: srv.URL + "/img/b.jpg"},
+	}, contents[0].Media)
+
+	// the details of known items are not read again
+	visits = nil
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+	assert.Equal(t, []string{"/search", "/search?page=2", "/search?page=3"}, visits)
+}
+
+func TestFilterSupport(t *testing.T) {
+	s := newTestScrapper(t)
+	_, err := s.HandleAction(context.Background(), &scrapperCommand{action: "define", definition: testDefinition}, nil)
+	assert.NoError(t, err)
+	check := func(text string) error {
+		c, err := s.ParseCommand(models.Command{Text: text})
+		assert.NoError(t, err)
+		def, err := s.definition(context.Background(), c.Platform())
+		assert.NoError(t, err)
+		return checkFilterSupport(def, &models.Subscription{Platform: c.Platform(), Options: c.Options()})
+	}
+
+	assert.NoError(t, check("add olx flats https://olx.example 60 min_bedrooms=2"))
+	assert.NoError(t, check("add olx flats https://olx.example 60 details=true max_condo_fee=800"))
+	assert.ErrorContains(t, check("add olx flats https://olx.example 60 max_iptu=200"), "olx reads iptu from the listing pages, add details=true to use max_iptu")
+	assert.ErrorContains(t, check("add homes flats https://homes.example 60 details=true"), "homes has no detail pages, details is not supported")
+	assert.ErrorContains(t, check("add zap_imoveis flats https://zap.example 60 details=true min_parking=1"), "zap_imoveis does not read parking, min_parking is not supported")
+}
+
+func TestAdPageDetail(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html><head><script>{"iptu":"R$ 1"}</script></head><body>
+			<div><img src="https://img.example/images/1.jpg"><img src="/logo.png"></div>
+			<dl><dt>Condomínio</dt><dd>R$ 450</dd><dt>IPTU</dt><dd>R$ 1.200</dd></dl></body></html>`))
+	}))
+	defer srv.Close()
+
+	def := &Definition{Name: "ads", Item: "li", Fields: map[string]*Field{}, Detail: adPageDetail("img.example/images")}
+	assert.NoError(t, compileFields(def.Detail.Fields))
+	s := newTestScrapper(t)
+	v := detailsVisitor{def: def, c: s.client.collector(context.Background()), limit: 1}
+	it := item{Link: srv.URL}
+	assert.True(t, v.visit(&it))
+	assert.Equal(t, "R$ 450", it.get(condoFeeField))
+	assert.Equal(t, "R$ 1.200", it.get(iptuField))
+	assert.Equal(t, []string{"https://img.example/images/1.jpg"}, it.Photos)
+}
+
+func TestPageUrl(t *testing.T) {
+	def := &Definition{PageParam: "pagina"}
+	assert.Equal(t, "https://zap.example/venda/?pagina=3&tipo=casa", def.pageUrl("https://zap.example/venda/?tipo=casa", 3))
+	assert.Empty(t, (&Definition{}).pageUrl("https://zap.example/venda/", 2))
+}
@@ -0,0 +1,417 @@
+package scrapper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly/v2"
+
+	ge "github.com/camopy/rss_everything/util/generics"
+)
+
+const (
+	definitionsTable = "scrapper:definitions:"
+
+	titleField    = "title"
+	linkField     = "link"
+	priceField    = "price"
+	locationField = "location"
+	imageField    = "image"
+	// photosField collects every match instead of the first one.
+	photosField = "photos"
+
+	// the built-in definitions read these fields the same way on every
+	// site: area and counts as plain numbers, fees as prices.
+	areaField          = "area"
+	bedroomsField      = "bedrooms"
+	parkingField       = "parking"
+	condoFeeField      = "condo_fee"
+	iptuField          = "iptu"
+	neighbourhoodField = "neighbourhood"
+)
+
+// areaNumber is the regex of an area in the built-in definitions, thousands
+// separators included so "1.200 m²" is not read as 1 or 200.
+const areaNumber = `(\d{1,3}(?:\.\d{3})+|\d+)`
+
+var (
+	definitionNameRe = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	requiredFields   = []string{titleField, linkField}
+	spacesRe         = regexp.MustCompile(`\s+`)
+)
+
+// Definition describes how to read the items of a search results page, so a
+// site can be scraped without code of its own. Definitions are built in,
+// loaded from a file or added with the define command, e.g.
+//
+//	{
+//	  "name": "example",
+//	  "item": "ul.results li",
+//	  "fields": {
+//	    "title": {"selector": "h2"},
+//	    "link": {"selector": "a", "attr": "href", "absolute": true},
+//	    "price": {"selector": ".price", "regex": "R\\$\\s*[\\d.]+"}
+//	  },
+//	  "page_param": "page",
+//	  "empty": "p.no-results",
+//	  "detail": {
+//	    "fields": {
+//	      "area": {"selector": ".area", "regex": "(\\d+) m²"},
+//	      "photos": {"selector": ".gallery img", "attr": "src", "absolute": true}
+//	    }
+//	  }
+//	}
+type Definition struct {
+	Name string `json:"name"`
+	// Item is the CSS selector of every item of the page.
+	Item string `json:"item"`
+	// Fields are read from each item. title and link are required, price,
+	// location and image are shown in their own place and any other field
+	// is listed below them.
+	Fields map[string]*Field `json:"fields"`
+	// Next is the CSS selector of the link to the next page of results.
+	Next string `json:"next,omitempty"`
+	// PageParam is the query parameter of the page number, used when there
+	// is no Next link.
+	PageParam string `json:"page_param,omitempty"`
+	// Empty is the CSS selector of the message of a search without results,
+	// e.g. p:contains("Nenhum resultado"), so such a page is not taken for a
+	// layout change.
+	Empty string `json:"empty,omitempty"`
+	// Detail reads more fields from the page of each new item, such as area,
+	// bedrooms, parking, condo_fee, iptu and photos.
+	Detail *Detail `json:"detail,omitempty"`
+}
+
+// Detail describes the page of a single item.
+type Detail struct {
+	Fields map[string]*Field `json:"fields"`
+}
+
+// adPageDetail reads the fees labelled in the text of an ad page, as in
+// "Condomínio R$ 450", which holds across most markup changes, and the
+// photos served from the given image host.
+func adPageDetail(imageHost string) *Detail {
+	return &Detail{Fields: map[string]*Field{
+		condoFeeField: {Selector: "body", Remove: "script, style", Regex: `Condomínio\s*(R\$\s*[\d.,]+)`},
+		iptuField:     {Selector: "body", Remove: "script, style", Regex: `IPTU\s*(R\$\s*[\d.,]+)`},
+		photosField:   {Selector: fmt.Sprintf("img[src*='%s']", imageHost), Attr: "src", Absolute: true},
+	}}
+}
+
+// reads reports whether the definition reads the field from the results
+// page, or from the detail pages when details are read.
+func (d *Definition) reads(field string, details bool) bool {
+	return d.Fields[field] != nil || details && d.Detail != nil && d.Detail.Fields[field] != nil
+}
+
+// Field reads a value from an item.
+type Field struct {
+	// Selector is relative to the item, the item itself when empty.
+	Selector string `json:"selector,omitempty"`
+	// Fallbacks are tried in order when Selector yields nothing.
+	Fallbacks []string `json:"fallbacks,omitempty"`
+	// Attr is the attribute to read, the text when empty.
+	Attr string `json:"attr,omitempty"`
+	// Remove drops the matching children before reading the text.
+	Remove string `json:"remove,omitempty"`
+	// Regex keeps the first group of the match, or the whole match when it
+	// has no group.
+	Regex string `json:"regex,omitempty"`
+	// Absolute resolves the value as a url relative to the page.
+	Absolute bool `json:"absolute,omitempty"`
+	// Required skips items without the field, as for title and link.
+	Required bool `json:"required,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// parseDefinition decodes and checks a definition.
+func parseDefinition(data []byte) (*Definition, error) {
+	var def Definition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, fmt.Errorf("invalid definition: %w", err)
+	}
+	if err := def.compile(); err != nil {
+		return nil, err
+	}
+	return &def, nil
+}
+
+// compile checks the definition and compiles the regular expressions of its
+// fields.
+func (d *Definition) compile() error {
+	if !definitionNameRe.MatchString(d.Name) {
+		return fmt.Errorf("invalid definition name %q, use lowercase letters, digits, - and _", d.Name)
+	}
+	if strings.TrimSpace(d.Item) == "" {
+		return fmt.Errorf("definition %s: item selector is required", d.Name)
+	}
+	for _, name := range requiredFields {
+		if d.Fields[name] == nil {
+			return fmt.Errorf("definition %s: field %s is required", d.Name, name)
+		}
+	}
+	if err := compileFields(d.Fields); err != nil {
+		return fmt.Errorf("definition %s: %w", d.Name, err)
+	}
+	if d.Detail != nil {
+		if err := compileFields(d.Detail.Fields); err != nil {
+			return fmt.Errorf("definition %s: detail: %w", d.Name, err)
+		}
+	}
+	return nil
+}
+
+func compileFields(fields map[string]*Field) error {
+	for name, f := range fields {
+		if f == nil {
+			return fmt.Errorf("field %s is empty", name)
+		}
+		if f.Regex == "" {
+			continue
+		}
+		re, err := regexp.Compile(f.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid regex of field %s: %w", name, err)
+		}
+		f.re = re
+	}
+	return nil
+}
+
+// extract reads the fields of an item element.
+func (d *Definition) extract(e *colly.HTMLElement) item {
+	it := item{}
+	extractFields(d.Fields, e, &it)
+	return it
+}
+
+// extract fills the fields the item does not have yet from its detail page.
+func (d *Detail) extract(e *colly.HTMLElement, it *item) {
+	fields := make(map[string]*Field, len(d.Fields))
+	for name, f := range d.Fields {
+		if ge.Cond(name == photosField, len(it.Photos) == 0, it.get(name) == "") {
+			fields[name] = f
+		}
+	}
+	extractFields(fields, e, it)
+}
+
+func extractFields(fields map[string]*Field, e *colly.HTMLElement, it *item) {
+	for name, f := range fields {
+		if name == photosField {
+			it.Photos = f.extractAll(e)
+			continue
+		}
+		it.set(name, f.extract(e))
+	}
+}
+
+// missing returns the required fields the item has no value for.
+func (d *Definition) missing(it item) []string {
+	var missing []string
+	for name, f := range d.Fields {
+		if (f.Required || slices.Contains(requiredFields, name)) && it.get(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+func (f *Field) extract(e *colly.HTMLElement) string {
+	for _, selector := range append([]string{f.Selector}, f.Fallbacks...) {
+		s := e.DOM
+		if selector != "" {
+			s = s.Find(selector).First()
+		}
+		if s.Length() == 0 {
+			continue
+		}
+		if v := f.value(s); v != "" {
+			return ge.Cond(f.Absolute, e.Request.AbsoluteURL(v), v)
+		}
+	}
+	return ""
+}
+
+func (f *Field) extractAll(e *colly.HTMLElement) []string {
+	for _, selector := range append([]string{f.Selector}, f.Fallbacks...) {
+		s := e.DOM
+		if selector != "" {
+			s = s.Find(selector)
+		}
+		var values []string
+		s.Each(func(_ int, s *goquery.Selection) {
+			if v := f.value(s); v != "" {
+				values = append(values, ge.Cond(f.Absolute, e.Request.AbsoluteURL(v), v))
+			}
+		})
+		if len(values) > 0 {
+			return ge.Unique(values)
+		}
+	}
+	return nil
+}
+
+func (f *Field) value(s *goquery.Selection) string {
+	var v string
+	if f.Attr != "" {
+		v = s.AttrOr(f.Attr, "")
+	} else {
+		if f.Remove != "" {
+			s = s.Clone()
+			s.Find(f.Remove).Remove()
+		}
+		v = s.Text()
+	}
+	v = strings.TrimSpace(spacesRe.ReplaceAllString(v, " "))
+	if f.re == nil || v == "" {
+		return v
+	}
+	m := f.re.FindStringSubmatch(v)
+	switch {
+	case m == nil:
+		return ""
+	case len(m) > 1:
+		return strings.TrimSpace(m[1])
+	}
+	return strings.TrimSpace(m[0])
+}
+
+// loadDefinitions reads a JSON file holding a list of definitions.
+func loadDefinitions(path string) (map[string]*Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid definitions file %s: %w", path, err)
+	}
+	defs := make(map[string]*Definition, len(raw))
+	for _, r := range raw {
+		def, err := parseDefinition(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		defs[def.Name] = def
+	}
+	return defs, nil
+}
+
+// definition returns the definition of the platform. Built-in definitions
+// come first, then the ones of the definitions file and the stored ones.
+func (u *Scrapper) definition(ctx context.Context, platform string) (*Definition, error) {
+	platform = strings.ToLower(platform)
+	if def, ok := builtinDefinitions[platform]; ok {
+		return def, nil
+	}
+	if def, ok := u.fileDefinitions[platform]; ok {
+		return def, nil
+	}
+	id, def, err := u.storedDefinition(ctx, platform)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, fmt.Errorf("scrapper: unknown platform %s, see /scrapper definitions", platform)
+	}
+	return def, nil
+}
+
+// storedDefinitions returns the definitions added with the define command by
+// their id in the definitions table.
+func (u *Scrapper) storedDefinitions(ctx context.Context) (map[string]*Definition, error) {
+	rows, err := u.db.List(ctx, definitionsTable)
+	if err != nil && !u.db.IsErrNotFound(err) {
+		return nil, err
+	}
+	defs := make(map[string]*Definition, len(rows))
+	for id, row := range rows {
+		def, err := parseDefinition([]byte(row))
+		if err != nil {
+			u.logger.Error(fmt.Sprintf("skipping stored scrapper definition %s: %v", id, err))
+			continue
+		}
+		defs[id] = def
+	}
+	return defs, nil
+}
+
+func (u *Scrapper) storedDefinition(ctx context.Context, name string) (string, *Definition, error) {
+	defs, err := u.storedDefinitions(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	for id, def := range defs {
+		if def.Name == name {
+			return id, def, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// define stores the definition, replacing the stored one of the same name.
+func (u *Scrapper) define(ctx context.Context, data []byte) (*Definition, error) {
+	def, err := parseDefinition(data)
+	if err != nil {
+		return nil, fmt.Errorf("scrapper: %w", err)
+	}
+	if _, ok := builtinDefinitions[def.Name]; ok {
+		return nil, fmt.Errorf("scrapper: %s is a built-in platform", def.Name)
+	}
+	if _, ok := u.fileDefinitions[def.Name]; ok {
+		return nil, fmt.Errorf("scrapper: %s is defined in the definitions file", def.Name)
+	}
+
+	id, _, err := u.storedDefinition(ctx, def.Name)
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(def)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := u.db.Add(ctx, definitionsTable, value); err != nil {
+		return nil, err
+	}
+	if id != "" {
+		if err := u.db.Del(ctx, definitionsTable, id); err != nil {
+			return nil, err
+		}
+	}
+	return def, nil
+}
+
+func (u *Scrapper) undefine(ctx context.Context, name string) error {
+	id, _, err := u.storedDefinition(ctx, strings.ToLower(name))
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		return fmt.Errorf("scrapper: definition %s not found", name)
+	}
+	return u.db.Del(ctx, definitionsTable, id)
+}
+
+// definitionNames lists the platforms that can be scraped.
+func (u *Scrapper) definitionNames(ctx context.Context) ([]string, error) {
+	names := ge.MKeys(builtinDefinitions)
+	names = append(names, ge.MKeys(u.fileDefinitions)...)
+	stored, err := u.storedDefinitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, def := range stored {
+		names = append(names, def.Name)
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
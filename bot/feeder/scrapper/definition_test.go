@@ -0,0 +1,115 @@
+package scrapper
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+const testDefinition = `{
+	"name": "homes",
+	"item": "ul.results li",
+	"fields": {
+		"title": {"selector": "h2"},
+		"link": {"selector": "a", "attr": "href", "absolute": true},
+		"price": {"selector": ".price", "fallbacks": [".old-price"], "regex": "R\\$\\s*[\\d.]+"},
+		"location": {"selector": ".where", "remove": "span"},
+		"image": {"selector": "img", "attr": "src", "absolute": true},
+		"bedrooms": {"selector": ".rooms", "regex": "(\\d+) quartos"}
+	}
+}`
+
+const testPage = `<html><body><ul class="results">
+<li><a href="/homes/1"><h2>Casa com quintal</h2></a><p class="price">Aluguel R$ 2.500 /mês</p>
+	<p class="where">Pinheiros <span>Rua A, 10</span></p><img src="/img/1.jpg"><p class="rooms">3 quartos</p></li>
+<li><a href="https://homes.example/homes/2"><h2>Apartamento</h2></a><p class="old-price">R$ 1.900</p></li>
+<li><p>ad without title</p></li>
+</ul></body></html>`
+
+func TestDefinitionFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testPage))
+	}))
+	defer srv.Close()
+
+	s := newTestScrapper(t)
+	ctx := context.Background()
+
+	reply, err := s.HandleAction(ctx, &scrapperCommand{action: "define", definition: testDefinition}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "scrapper: defined homes", reply)
+
+	sub := &models.Subscription{Name: "homes", Platform: "homes", Url: srv.URL + "/search"}
+	contents, err := s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 2)
+	assert.Equal(t, "Casa com quintal\nPinheiros\nR$ 2.500\nbedrooms: 3\n\n"+srv.URL+"/homes/1", contents[0].Text)
+	assert.Equal(t, []models.Media{{Type: models.MediaPhoto, Url: srv.URL + "/img/1.jpg"}}, contents[0].Media)
+	assert.Equal(t, "Apartamento\nR$ 1.900\n\nhttps://homes.example/homes/2", contents[1].Text)
+
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+
+	names, err := s.definitionNames(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"homes", OlxPlatform, ZapImoveisPlatform}, names)
+
+	_, err = s.HandleAction(ctx, &scrapperCommand{action: "undefine", platform: "homes"}, nil)
+	assert.NoError(t, err)
+	_, err = s.Fetch(ctx, sub)
+	assert.ErrorContains(t, err, "unknown platform homes")
+}
+
+func TestParseDefinition(t *testing.T) {
+	_, err := parseDefinition([]byte(`{"name": "Bad Name", "item": "li"}`))
+	assert.ErrorContains(t, err, "invalid definition name")
+
+	_, err = parseDefinition([]byte(`{"name": "site", "item": "li", "fields": {"title": {}}}`))
+	assert.ErrorContains(t, err, "field link is required")
+
+	_, err = parseDefinition([]byte(`{"name": "site", "item": "li", "fields": {"title": {}, "link": {"regex": "("}}}`))
+	assert.ErrorContains(t, err, "invalid regex of field link")
+
+	s := &Scrapper{}
+	_, err = s.define(context.Background(), []byte(`{"name": "olx", "item": "li", "fields": {"title": {}, "link": {}}}`))
+	assert.ErrorContains(t, err, "olx is a built-in platform")
+}
+
+func TestBuiltinAreas(t *testing.T) {
+	// the text each site shows an area in
+	areas := map[string]string{
+		OlxPlatform:        "1.200 metros quadrados",
+		ZapImoveisPlatform: "Casa com 3 quartos à venda, 1.200m² em Vila Madalena",
+	}
+	for name, def := range builtinDefinitions {
+		text, ok := areas[name]
+		assert.True(t, ok, name)
+		m := def.Fields[areaField].re.FindStringSubmatch(text)
+		assert.Equal(t, []string{"1.200"}, m[1:], name)
+		v, ok := parsePrice(m[1])
+		assert.True(t, ok)
+		assert.Equal(t, int64(120000), v.Cents)
+
+		m = def.Fields[areaField].re.FindStringSubmatch(strings.ReplaceAll(text, "1.200", "85"))
+		assert.Equal(t, []string{"85"}, m[1:], name)
+	}
+}
+
+func TestDefinitionsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "definitions.json")
+	assert.NoError(t, os.WriteFile(path, []byte("["+testDefinition+"]"), 0o600))
+
+	s := newTestScrapper(t, WithDefinitionsFile(path))
+	def, err := s.definition(context.Background(), "homes")
+	assert.NoError(t, err)
+	assert.Equal(t, "ul.results li", def.Item)
+}
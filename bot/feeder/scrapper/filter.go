@@ -0,0 +1,103 @@
+package scrapper
+
+import (
+	"fmt"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+const (
+	minAreaOption     = "min_area"
+	minBedroomsOption = "min_bedrooms"
+	minParkingOption  = "min_parking"
+	maxCondoFeeOption = "max_condo_fee"
+	maxIptuOption     = "max_iptu"
+)
+
+// fieldBounds are the filters on the numeric fields of items, mostly read
+// from their detail pages.
+var fieldBounds = []struct {
+	option string
+	field  string
+	isMin  bool
+}{
+	{minAreaOption, areaField, true},
+	{minBedroomsOption, bedroomsField, true},
+	{minParkingOption, parkingField, true},
+	{maxCondoFeeOption, condoFeeField, false},
+	{maxIptuOption, iptuField, false},
+}
+
+// itemFilter keeps the items of a subscription within its price range and
+// field bounds.
+type itemFilter struct {
+	price  priceFilter
+	bounds []fieldBound
+}
+
+// fieldBound is a minimum or maximum of a field, in cents like prices.
+type fieldBound struct {
+	field string
+	isMin bool
+	cents int64
+}
+
+func subscriptionFilter(sub *models.Subscription) itemFilter {
+	f := itemFilter{price: subscriptionPriceFilter(sub)}
+	for _, b := range fieldBounds {
+		if p, ok := parsePrice(sub.Options[b.option]); ok {
+			f.bounds = append(f.bounds, fieldBound{field: b.field, isMin: b.isMin, cents: p.Cents})
+		}
+	}
+	return f
+}
+
+// matches reports whether the item passes the filters. Items without a
+// filtered field do not.
+func (f itemFilter) matches(it item) bool {
+	if !f.price.matches(it) {
+		return false
+	}
+	for _, b := range f.bounds {
+		v, ok := parsePrice(it.get(b.field))
+		if !ok || b.isMin && v.Cents < b.cents || !b.isMin && v.Cents > b.cents {
+			return false
+		}
+	}
+	return true
+}
+
+// checkFilterSupport returns an error for the options of the subscription
+// its definition cannot apply: details without detail pages, and bounds on
+// fields the definition does not read, which would skip every item.
+func checkFilterSupport(def *Definition, sub *models.Subscription) error {
+	details := subscriptionCrawl(sub).details
+	if details && def.Detail == nil {
+		return fmt.Errorf("%s has no detail pages, %s is not supported", def.Name, detailsOption)
+	}
+	for _, b := range fieldBounds {
+		if _, ok := sub.Options[b.option]; !ok || def.reads(b.field, details) {
+			continue
+		}
+		if def.reads(b.field, true) {
+			return fmt.Errorf("%s reads %s from the listing pages, add %s=true to use %s", def.Name, b.field, detailsOption, b.option)
+		}
+		return fmt.Errorf("%s does not read %s, %s is not supported", def.Name, b.field, b.option)
+	}
+	return nil
+}
+
+// parseFilterOptions checks the filter flags of an add command.
+func parseFilterOptions(opts map[string]string) error {
+	if err := parsePriceOptions(opts); err != nil {
+		return err
+	}
+	for _, b := range fieldBounds {
+		if v, ok := opts[b.option]; ok {
+			if _, ok := parsePrice(v); !ok {
+				return fmt.Errorf("invalid %s %q", b.option, v)
+			}
+		}
+	}
+	return nil
+}
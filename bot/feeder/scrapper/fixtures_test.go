@@ -0,0 +1,159 @@
+package scrapper
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+	"github.com/camopy/rss_everything/db"
+	"github.com/camopy/rss_everything/zaplog"
+)
+
+var updateFixtures = flag.Bool("update", false, "rewrite the expected items of the scrapper fixtures")
+
+// fixtureHost replaces the address of the fixture server in the expected
+// items, so links made absolute do not depend on its port.
+const fixtureHost = "http://fixture.test"
+
+// newTestScrapper returns a scrapper on a memory db that does not wait
+// between requests.
+func newTestScrapper(t *testing.T, opts ...Option) *Scrapper {
+	t.Helper()
+	s := New(zaplog.L(), db.NewMemory(), opts...).(*Scrapper)
+	s.client.delay = 0
+	return s
+}
+
+// serveFixture serves testdata/<name> on every path.
+func serveFixture(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	page, err := os.ReadFile(filepath.Join("testdata", name))
+	assert.NoError(t, err)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(page)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestFixtures runs every built-in definition against its saved page,
+// testdata/<platform>.html, and compares the items with the expected ones in
+// testdata/<platform>.json. Run with -update after saving a new page.
+func TestFixtures(t *testing.T) {
+	s := newTestScrapper(t)
+	for name, def := range builtinDefinitions {
+		t.Run(name, func(t *testing.T) {
+			srv := serveFixture(t, name+".html")
+			res, err := s.scrap(s.client.collector(context.Background()), def, srv.URL+"/search", 1)
+			assert.NoError(t, err)
+			assert.Empty(t, res.breakage(def))
+
+			got, err := json.MarshalIndent(res.items, "", "  ")
+			assert.NoError(t, err)
+			got = []byte(strings.ReplaceAll(string(got), srv.URL, fixtureHost) + "\n")
+
+			expectedFile := filepath.Join("testdata", name+".json")
+			if *updateFixtures {
+				assert.NoError(t, os.WriteFile(expectedFile, got, 0o644))
+			}
+			expected, err := os.ReadFile(expectedFile)
+			assert.NoError(t, err)
+			assert.JSONEq(t, string(expected), string(got))
+		})
+	}
+}
+
+func TestBreakageAlert(t *testing.T) {
+	pages := make(map[string][]byte)
+	for _, name := range []string{"olx.html", "zap_imoveis.html"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		assert.NoError(t, err)
+		pages[name] = data
+	}
+	page := "olx.html"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(pages[page])
+	}))
+	defer srv.Close()
+
+	s := newTestScrapper(t)
+	sub := &models.Subscription{Name: "apartments", Platform: OlxPlatform, Url: srv.URL, ThreadId: 7}
+	ctx := context.Background()
+
+	contents, err := s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 2)
+
+	// the page of another site has no OLX ad cards, which is only alerted
+	// when the next poll finds none either
+	page = "zap_imoveis.html"
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 1)
+	assert.Equal(t, 7, contents[0].ThreadId)
+	assert.Contains(t, contents[0].Text, "⚠️ scrapper: apartments found no usable listings, the olx page layout may have changed")
+	assert.Contains(t, contents[0].Text, `no element matches the item selector "section"`)
+
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+
+	page = "olx.html"
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 1)
+	assert.Equal(t, "✅ scrapper: apartments is finding listings again", contents[0].Text)
+
+	// a single poll without items recovers silently
+	page = "zap_imoveis.html"
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+	page = "olx.html"
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+}
+
+func TestBreakageEmptySearch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html><body><p class="notice">Nenhum resultado para a busca</p></body></html>`))
+	}))
+	defer srv.Close()
+
+	s := newTestScrapper(t)
+	def := &Definition{Name: "site", Item: "li", Empty: `p:contains("Nenhum resultado")`, Fields: map[string]*Field{titleField: {}, linkField: {}}}
+	res, err := s.scrap(s.client.collector(context.Background()), def, srv.URL, 1)
+	assert.NoError(t, err)
+	assert.True(t, res.empty)
+	assert.Empty(t, res.breakage(def))
+
+	def.Empty = "p.no-results"
+	res, err = s.scrap(s.client.collector(context.Background()), def, srv.URL, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, `no element matches the item selector "li"`, res.breakage(def))
+}
+
+func TestBreakageMissingFields(t *testing.T) {
+	def := &Definition{Name: "site", Item: "li", Fields: map[string]*Field{
+		titleField: {}, linkField: {}, priceField: {Required: true},
+	}}
+	res := scrapResult{items: make([]item, 1), matched: 3, missing: map[string]int{priceField: 2, linkField: 1}}
+	assert.Equal(t, "2 of 3 items miss required fields: link (1), price (2)", res.breakage(def))
+
+	res = scrapResult{items: make([]item, 2), matched: 3, missing: map[string]int{priceField: 1}}
+	assert.Empty(t, res.breakage(def))
+}
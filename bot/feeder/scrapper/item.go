@@ -0,0 +1,117 @@
+package scrapper
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/camopy/rss_everything/bot/models"
+	ge "github.com/camopy/rss_everything/util/generics"
+)
+
+// item is a listing read from a search results page.
+type item struct {
+	Title    string            `json:"title"`
+	Link     string            `json:"link"`
+	Price    string            `json:"price"`
+	Location string            `json:"location"`
+	Image    string            `json:"image"`
+	Extra    map[string]string `json:"extra,omitempty"`
+	Photos   []string          `json:"photos,omitempty"`
+	// Detailed is set once the detail page of the item was visited.
+	Detailed bool `json:"detailed,omitempty"`
+}
+
+// maxPhotos is the most photos sent with an item, the size limit of a
+// Telegram album.
+const maxPhotos = 10
+
+func (it *item) set(name, value string) {
+	switch name {
+	case titleField:
+		it.Title = value
+	case linkField:
+		it.Link = value
+	case priceField:
+		it.Price = value
+	case locationField:
+		it.Location = value
+	case imageField:
+		it.Image = value
+	default:
+		if value == "" {
+			return
+		}
+		if it.Extra == nil {
+			it.Extra = make(map[string]string)
+		}
+		it.Extra[name] = value
+	}
+}
+
+// mergeDetails copies the details of prev, read from the detail page on an
+// earlier poll, that the item lacks.
+func (it *item) mergeDetails(prev item) {
+	if !prev.Detailed {
+		return
+	}
+	for name, value := range prev.Extra {
+		if it.get(name) == "" {
+			it.set(name, value)
+		}
+	}
+	if len(it.Photos) == 0 {
+		it.Photos = prev.Photos
+	}
+	it.Detailed = true
+}
+
+func (it item) get(name string) string {
+	switch name {
+	case titleField:
+		return it.Title
+	case linkField:
+		return it.Link
+	case priceField:
+		return it.Price
+	case locationField:
+		return it.Location
+	case imageField:
+		return it.Image
+	}
+	return it.Extra[name]
+}
+
+func (it item) String() string {
+	var b strings.Builder
+	for _, line := range []string{it.Title, it.Location, it.Price} {
+		if line != "" {
+			b.WriteString(line + "\n")
+		}
+	}
+	names := ge.MKeys(it.Extra)
+	slices.Sort(names)
+	for _, name := range names {
+		if name == neighbourhoodField && strings.Contains(it.Location, it.Extra[name]) {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("%s: %s\n", strings.ReplaceAll(name, "_", " "), it.Extra[name]))
+	}
+	b.WriteString("\n" + it.Link)
+	return b.String()
+}
+
+func (it item) content(threadId int) models.Content {
+	c := models.Content{
+		ThreadId: threadId,
+		Text:     it.String(),
+	}
+	photos := it.Photos
+	if len(photos) == 0 && it.Image != "" {
+		photos = []string{it.Image}
+	}
+	for _, photo := range photos[:min(len(photos), maxPhotos)] {
+		c.Media = append(c.Media, models.Media{Type: models.MediaPhoto, Url: photo})
+	}
+	return c
+}
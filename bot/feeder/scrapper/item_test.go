@@ -0,0 +1,22 @@
+package scrapper
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestItemString(t *testing.T) {
+	it := item{
+		Title:    "Apartamento 2 quartos",
+		Link:     "https://homes.example/1",
+		Price:    "R$ 650.000",
+		Location: "São Paulo, Pinheiros",
+		Extra:    map[string]string{neighbourhoodField: "Pinheiros", bedroomsField: "2", condoFeeField: "R$ 850"},
+	}
+	// the neighbourhood is not repeated when the location shows it
+	assert.Equal(t, "Apartamento 2 quartos\nSão Paulo, Pinheiros\nR$ 650.000\nbedrooms: 2\ncondo fee: R$ 850\n\nhttps://homes.example/1", it.String())
+
+	it.Location = "Rua Cardeal Arcoverde, 800"
+	assert.Contains(t, it.String(), "neighbourhood: Pinheiros\n")
+}
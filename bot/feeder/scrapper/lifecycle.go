@@ -0,0 +1,178 @@
+package scrapper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/camopy/rss_everything/bot/models"
+	ge "github.com/camopy/rss_everything/util/generics"
+)
+
+const (
+	goneAfterOption = "gone_after"
+	goneReplyOption = "gone_reply"
+
+	maxGoneAfter = 10
+	// maxGoneChecks bounds the listing pages checked by a poll.
+	maxGoneChecks = 10
+	// removedListingText is shown by the sites on the page of a removed
+	// listing, some with a 200 status.
+	removedListingText = "anúncio não encontrado"
+)
+
+// lifecycleOptions tell when a listing sent to a subscription is considered
+// gone from its results, usually because it was rented or sold.
+type lifecycleOptions struct {
+	// goneAfter is the number of polls in a row a listing must be missing,
+	// zero disables the notices.
+	goneAfter int
+	// reply sends the notice as a reply to the message of the listing.
+	reply bool
+}
+
+func subscriptionLifecycle(sub *models.Subscription) lifecycleOptions {
+	l := lifecycleOptions{reply: true}
+	if n, err := strconv.Atoi(sub.Options[goneAfterOption]); err == nil && n > 0 {
+		l.goneAfter = min(n, maxGoneAfter)
+	}
+	if reply, err := strconv.ParseBool(sub.Options[goneReplyOption]); err == nil {
+		l.reply = reply
+	}
+	return l
+}
+
+// parseLifecycleOptions checks the listing lifecycle flags of an add command.
+func parseLifecycleOptions(opts map[string]string) error {
+	if v, ok := opts[goneAfterOption]; ok {
+		if n, err := strconv.Atoi(v); err != nil || n < 1 || n > maxGoneAfter {
+			return fmt.Errorf("invalid %s %q, use 1 to %d", goneAfterOption, v, maxGoneAfter)
+		}
+	}
+	if v, ok := opts[goneReplyOption]; ok {
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("invalid %s %q, use true or false", goneReplyOption, v)
+		}
+	}
+	return nil
+}
+
+// activeListing is a listing sent to a subscription that was still in its
+// results lately.
+type activeListing struct {
+	Title string `json:"title"`
+	// Misses counts the polls in a row the listing was not found.
+	Misses int `json:"misses,omitempty"`
+}
+
+// updateActive marks the active listings of the subscription found in the
+// results and adds the sent ones. When misses are counted, a listing missing
+// for goneAfter polls in a row has its page checked: a removed listing gets
+// a notice and stops being tracked, one still up has likely moved past the
+// pages read and is kept.
+func (u *Scrapper) updateActive(ctx context.Context, sub *models.Subscription, opts lifecycleOptions, found []item, sent []item, countMisses bool) ([]models.Content, error) {
+	active, err := u.activeListings(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(found))
+	for _, it := range found {
+		seen[it.Link] = true
+	}
+	for _, it := range sent {
+		active[it.Link] = &activeListing{Title: it.Title}
+	}
+
+	var missing, gone []string
+	for link, l := range active {
+		if seen[link] {
+			l.Misses = 0
+			continue
+		}
+		if !countMisses {
+			continue
+		}
+		if l.Misses = min(l.Misses+1, opts.goneAfter); l.Misses == opts.goneAfter {
+			missing = append(missing, link)
+		}
+	}
+	slices.Sort(missing)
+	for i, link := range missing {
+		if i == maxGoneChecks {
+			// left at goneAfter misses, so they are checked next time
+			break
+		}
+		removed, err := u.listingRemoved(ctx, link)
+		if err != nil {
+			u.logger.Warn("failed to check listing", zap.String("link", link), zap.Error(err))
+			continue
+		}
+		if !removed {
+			active[link].Misses = 0
+			continue
+		}
+		gone = append(gone, link)
+	}
+
+	contents := make([]models.Content, 0, len(gone))
+	for _, link := range gone {
+		u.logger.Info("listing gone", zap.String("link", link), zap.String("subscription", sub.Name))
+		contents = append(contents, models.Content{
+			ThreadId: sub.ThreadId,
+			Text:     fmt.Sprintf("🏁 listing gone, it was probably rented or sold\n\n%s\n%s", active[link].Title, link),
+			ReplyTo:  ge.Cond(opts.reply, itemKey(sub.Name, link), ""),
+		})
+		delete(active, link)
+	}
+
+	value, err := json.Marshal(active)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.db.Set(ctx, activeKey(sub.Name), value, itemTTL); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// listingRemoved reports whether the page of a listing says it was removed:
+// it is not found, or shows the removed listing message of the sites.
+func (u *Scrapper) listingRemoved(ctx context.Context, link string) (bool, error) {
+	resp, err := u.client.req.R().SetContext(ctx).Get(link)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return true, nil
+	case resp.IsErrorState():
+		return false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return strings.Contains(strings.ToLower(resp.String()), removedListingText), nil
+}
+
+// activeListings returns the active listings of the subscription by link.
+func (u *Scrapper) activeListings(ctx context.Context, sub *models.Subscription) (map[string]*activeListing, error) {
+	active := make(map[string]*activeListing)
+	value, err := u.db.Get(ctx, activeKey(sub.Name))
+	if err != nil {
+		if u.db.IsErrNotFound(err) {
+			return active, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(value, &active); err != nil {
+		return nil, err
+	}
+	return active, nil
+}
+
+func activeKey(subName string) string {
+	return fmt.Sprintf("scrapper:%s:active", strings.ToLower(subName))
+}
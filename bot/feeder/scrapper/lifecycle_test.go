@@ -0,0 +1,165 @@
+package scrapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+func TestListingGone(t *testing.T) {
+	homes := []string{"1", "2"}
+	removed := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := strings.CutPrefix(r.URL.Path, "/homes/"); ok {
+			if removed[id] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+			return
+		}
+		var b strings.Builder
+		for _, id := range homes {
+			_, _ = fmt.Fprintf(&b, `<li><a href="/homes/%[1]s"><h2>Casa %[1]s</h2></a><p class="price">R$ 500.000</p></li>`, id)
+		}
+		_, _ = fmt.Fprintf(w, `<html><body><ul class="results">%s</ul></body></html>`, b.String())
+	}))
+	defer srv.Close()
+
+	s := newTestScrapper(t)
+	ctx := context.Background()
+	_, err := s.define(ctx, []byte(testDefinition))
+	assert.NoError(t, err)
+
+	c, err := s.ParseCommand(models.Command{Text: "add homes houses " + srv.URL + " 60 gone_after=2"})
+	assert.NoError(t, err)
+	sub := &models.Subscription{Name: c.SubName(), Platform: c.Platform(), Url: c.Url(), Options: c.Options(), ThreadId: 3}
+
+	contents, err := s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 2)
+	sentKey := contents[1].Key
+	assert.NotEmpty(t, sentKey)
+
+	homes = []string{"1"}
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+
+	// a page without listings does not count as a miss
+	homes = nil
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+
+	// the page of the listing is still up, it only left the results read
+	homes = []string{"1", "3"}
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 1)
+	assert.Contains(t, contents[0].Text, "Casa 3")
+
+	removed["2"] = true
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Content{{
+		ThreadId: 3,
+		Text:     "🏁 listing gone, it was probably rented or sold\n\nCasa 2\n" + srv.URL + "/homes/2",
+		ReplyTo:  sentKey,
+	}}, contents)
+
+	// a listing is only reported once, and again only if it is sent again
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+}
+
+func TestListingGoneFailedPage(t *testing.T) {
+	failing := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/homes/"):
+			w.WriteHeader(http.StatusGone)
+		case r.URL.Query().Get("page") == "2" && failing:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.URL.Query().Get("page") == "2":
+			_, _ = w.Write([]byte(`<html><body><ul class="results"><li><a href="/homes/2"><h2>Casa 2</h2></a></li></ul></body></html>`))
+		default:
+			_, _ = w.Write([]byte(`<html><body><ul class="results"><li><a href="/homes/1"><h2>Casa 1</h2></a></li></ul></body></html>`))
+		}
+	}))
+	defer srv.Close()
+
+	s := newTestScrapper(t)
+	ctx := context.Background()
+	_, err := s.define(ctx, []byte(`{"name": "paged", "item": "ul.results li", "page_param": "page",
+		"fields": {"title": {"selector": "h2"}, "link": {"selector": "a", "attr": "href", "absolute": true}}}`))
+	assert.NoError(t, err)
+	c, err := s.ParseCommand(models.Command{Text: "add paged houses " + srv.URL + "/search 60 pages=2 gone_after=1"})
+	assert.NoError(t, err)
+	sub := &models.Subscription{Name: c.SubName(), Platform: c.Platform(), Url: c.Url(), Options: c.Options()}
+
+	contents, err := s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 2)
+
+	// the listings of a page that failed are not missed
+	failing = true
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+
+	failing = false
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+}
+
+func TestListingRemoved(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/removed":
+			_, _ = w.Write([]byte(`<html><body><h1>Anúncio não encontrado</h1></body></html>`))
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/blocked":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			_, _ = w.Write([]byte(`<html><body><h1>Casa 1</h1></body></html>`))
+		}
+	}))
+	defer srv.Close()
+
+	s := newTestScrapper(t)
+	ctx := context.Background()
+	for path, want := range map[string]bool{"/removed": true, "/gone": true, "/up": false} {
+		removed, err := s.listingRemoved(ctx, srv.URL+path)
+		assert.NoError(t, err)
+		assert.Equal(t, want, removed, path)
+	}
+	_, err := s.listingRemoved(ctx, srv.URL+"/blocked")
+	assert.ErrorContains(t, err, "unexpected status 403")
+}
+
+func TestParseLifecycleOptions(t *testing.T) {
+	s := &Scrapper{}
+	c, err := s.ParseCommand(models.Command{Text: "add olx flats https://olx.example 60 gone_after=3 gone_reply=false"})
+	assert.NoError(t, err)
+	l := subscriptionLifecycle(&models.Subscription{Options: c.Options()})
+	assert.Equal(t, lifecycleOptions{goneAfter: 3, reply: false}, l)
+
+	assert.Equal(t, lifecycleOptions{reply: true}, subscriptionLifecycle(&models.Subscription{}))
+
+	_, err = s.ParseCommand(models.Command{Text: "add olx flats https://olx.example 60 gone_after=0"})
+	assert.ErrorContains(t, err, "invalid gone_after")
+	_, err = s.ParseCommand(models.Command{Text: "add olx flats https://olx.example 60 gone_reply=maybe"})
+	assert.ErrorContains(t, err, "invalid gone_reply")
+}
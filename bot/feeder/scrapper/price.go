@@ -0,0 +1,136 @@
+package scrapper
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/camopy/rss_everything/bot/models"
+	ge "github.com/camopy/rss_everything/util/generics"
+)
+
+const (
+	minPriceOption = "min_price"
+	maxPriceOption = "max_price"
+)
+
+var (
+	priceNumberRe = regexp.MustCompile(`\d[\d.,]*`)
+	// currencies maps price prefixes to currency codes, longest first so
+	// US$ is not read as $.
+	currencies = []struct{ symbol, code string }{
+		{"R$", "BRL"},
+		{"US$", "USD"},
+		{"€", "EUR"},
+		{"£", "GBP"},
+		{"$", "USD"},
+	}
+)
+
+// price is a parsed listing price. Amounts are kept in cents.
+type price struct {
+	Cents    int64  `json:"cents"`
+	Currency string `json:"currency,omitempty"`
+}
+
+// parsePrice reads prices such as "R$ 1.250.000", "R$ 1.450,50" or
+// "US$ 1,250.00". A single separator followed by three digits is taken as a
+// thousands separator.
+func parsePrice(s string) (price, bool) {
+	number := priceNumberRe.FindString(s)
+	if number == "" {
+		return price{}, false
+	}
+	var p price
+	for _, c := range currencies {
+		if strings.Contains(s, c.symbol) {
+			p.Currency = c.code
+			break
+		}
+	}
+
+	number = strings.TrimRight(number, ".,")
+	decimals := ""
+	if i := strings.LastIndexAny(number, ".,"); i >= 0 {
+		sep := number[i]
+		after := number[i+1:]
+		other := ge.Cond(sep == '.', byte(','), byte('.'))
+		isDecimal := strings.IndexByte(number, other) >= 0 ||
+			strings.Count(number, string(sep)) == 1 && len(after) != 3
+		if isDecimal {
+			number, decimals = number[:i], after
+		}
+	}
+	number = strings.NewReplacer(".", "", ",", "").Replace(number)
+	units, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return price{}, false
+	}
+	decimals = (decimals + "00")[:2]
+	cents, _ := strconv.ParseInt(decimals, 10, 64)
+	p.Cents = units*100 + cents
+	return p, true
+}
+
+// percentChange is the change from p to next, in percent.
+func (p price) percentChange(next price) float64 {
+	if p.Cents == 0 {
+		return 0
+	}
+	return float64(next.Cents-p.Cents) * 100 / float64(p.Cents)
+}
+
+// priceFilter keeps the items of a subscription within its price range.
+// Amounts are in cents, zero when unset.
+type priceFilter struct {
+	min, max int64
+}
+
+func subscriptionPriceFilter(sub *models.Subscription) priceFilter {
+	var f priceFilter
+	if p, ok := parsePrice(sub.Options[minPriceOption]); ok {
+		f.min = p.Cents
+	}
+	if p, ok := parsePrice(sub.Options[maxPriceOption]); ok {
+		f.max = p.Cents
+	}
+	return f
+}
+
+func (f priceFilter) isSet() bool {
+	return f.min > 0 || f.max > 0
+}
+
+// matches reports whether the item is within the range. Items without a
+// price only match when no range is set.
+func (f priceFilter) matches(it item) bool {
+	if !f.isSet() {
+		return true
+	}
+	p, ok := parsePrice(it.Price)
+	if !ok {
+		return false
+	}
+	return (f.min == 0 || p.Cents >= f.min) && (f.max == 0 || p.Cents <= f.max)
+}
+
+// parsePriceOptions checks the price range flags of an add command.
+func parsePriceOptions(opts map[string]string) error {
+	var prices [2]price
+	for i, name := range []string{minPriceOption, maxPriceOption} {
+		v, ok := opts[name]
+		if !ok {
+			continue
+		}
+		p, ok := parsePrice(v)
+		if !ok {
+			return fmt.Errorf("invalid %s %q", name, v)
+		}
+		prices[i] = p
+	}
+	if prices[0].Cents > 0 && prices[1].Cents > 0 && prices[0].Cents > prices[1].Cents {
+		return fmt.Errorf("%s is greater than %s", minPriceOption, maxPriceOption)
+	}
+	return nil
+}
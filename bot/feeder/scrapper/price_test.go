@@ -0,0 +1,100 @@
+package scrapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+func TestParsePrice(t *testing.T) {
+	tests := []struct {
+		in    string
+		price price
+	}{
+		{"R$ 1.250.000", price{125000000, "BRL"}},
+		{"R$ 1.450,50 /mês", price{145050, "BRL"}},
+		{"R$ 2.500", price{250000, "BRL"}},
+		{"US$ 1,250.00", price{125000, "USD"}},
+		{"€ 980", price{98000, "EUR"}},
+		{"300000", price{30000000, ""}},
+		{"1.5", price{150, ""}},
+	}
+	for _, tt := range tests {
+		p, ok := parsePrice(tt.in)
+		assert.True(t, ok, tt.in)
+		assert.Equal(t, tt.price, p, tt.in)
+	}
+
+	_, ok := parsePrice("Consulte o preço")
+	assert.False(t, ok)
+}
+
+func TestParsePriceOptions(t *testing.T) {
+	s := &Scrapper{}
+	c, err := s.ParseCommand(models.Command{Text: `add olx flats https://olx.example 60 min_price=300000 max_price="R$ 1.200.000"`})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{minPriceOption: "300000", maxPriceOption: "R$ 1.200.000"}, c.Options())
+
+	_, err = s.ParseCommand(models.Command{Text: "add olx flats https://olx.example 60 min_price=2000 max_price=1000"})
+	assert.ErrorContains(t, err, "min_price is greater than max_price")
+
+	_, err = s.ParseCommand(models.Command{Text: "add olx flats https://olx.example 60 max_price=cheap"})
+	assert.ErrorContains(t, err, `invalid max_price "cheap"`)
+}
+
+const testPricePage = `<html><body><ul class="results">
+<li><a href="/homes/1"><h2>Casa</h2></a><p class="price">%s</p></li>
+<li><a href="/homes/2"><h2>Mansão</h2></a><p class="price">R$ 9.000.000</p></li>
+</ul></body></html>`
+
+func TestPriceTracking(t *testing.T) {
+	housePrice := "R$ 1.250.000"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, testPricePage, housePrice)
+	}))
+	defer srv.Close()
+
+	s := newTestScrapper(t)
+	ctx := context.Background()
+	_, err := s.define(ctx, []byte(testDefinition))
+	assert.NoError(t, err)
+	sub := &models.Subscription{
+		Name:     "houses",
+		Platform: "homes",
+		Url:      srv.URL,
+		Options:  map[string]string{maxPriceOption: "1200000"},
+	}
+
+	contents, err := s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+
+	// entering the price range sends the listing as new
+	housePrice = "R$ 1.190.000"
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 1)
+	assert.Equal(t, "Casa\nR$ 1.190.000\n\n"+srv.URL+"/homes/1", contents[0].Text)
+
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+
+	housePrice = "R$ 1.100.000"
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 1)
+	assert.Equal(t, "📉 price dropped from R$ 1.190.000 to R$ 1.100.000 (-7.6%)\n\nCasa\nR$ 1.100.000\n\n"+srv.URL+"/homes/1", contents[0].Text)
+
+	// a price increase is only recorded
+	housePrice = "R$ 1.150.000"
+	contents, err = s.Fetch(ctx, sub)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+}
@@ -3,7 +3,8 @@ package scrapper
 import (
 	"context"
 	"fmt"
-	"strconv"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/camopy/rss_everything/bot/feeder"
 	"github.com/camopy/rss_everything/bot/models"
 	"github.com/camopy/rss_everything/db"
+	ge "github.com/camopy/rss_everything/util/generics"
 	"github.com/camopy/rss_everything/zaplog"
 )
 
@@ -19,18 +21,72 @@ const (
 	scrapperSubscriptionsTable = "scrapper:subscriptions:"
 	OlxPlatform                = "olx"
 	ZapImoveisPlatform         = "zap_imoveis"
+
+	itemTTL = 30 * 24 * time.Hour
+)
+
+var builtinDefinitions = compileDefinitions(
+	olxDefinition,
+	zapImoveisDefinition,
 )
 
+func compileDefinitions(defs ...*Definition) map[string]*Definition {
+	m := make(map[string]*Definition, len(defs))
+	for _, def := range defs {
+		if err := def.compile(); err != nil {
+			panic(err)
+		}
+		m[def.Name] = def
+	}
+	return m
+}
+
 type Scrapper struct {
 	logger *zaplog.Logger
 	db     db.DB
+
+	definitionsFile string
+	fileDefinitions map[string]*Definition
+	clientConfig    ClientConfig
+	client          *client
+	// clientErr disables the scrapper when its client cannot be made.
+	clientErr error
+}
+
+type Option func(*Scrapper)
+
+// WithDefinitionsFile loads the scraper definitions of a JSON file holding a
+// list of them.
+func WithDefinitionsFile(path string) Option {
+	return func(s *Scrapper) {
+		s.definitionsFile = path
+	}
 }
 
-func New(logger *zaplog.Logger, db db.DB) feeder.Feeder {
-	return &Scrapper{
+func New(logger *zaplog.Logger, db db.DB, opts ...Option) feeder.Feeder {
+	s := &Scrapper{
 		logger: logger,
 		db:     db,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	// the config is validated at startup, a client that cannot be made
+	// disables the scrapper rather than scraping without its proxies
+	cl, err := newClient(logger, db, s.clientConfig)
+	if err != nil {
+		logger.Error("failed to configure the scrapper client, the scrapper is disabled", zap.Error(err))
+		s.clientErr = err
+	}
+	s.client = cl
+	if s.definitionsFile != "" {
+		defs, err := loadDefinitions(s.definitionsFile)
+		if err != nil {
+			logger.Error("failed to load scrapper definitions", zap.String("file", s.definitionsFile), zap.Error(err))
+		}
+		s.fileDefinitions = defs
+	}
+	return s
 }
 
 func (u *Scrapper) Name() string {
@@ -42,12 +98,14 @@ func (u *Scrapper) TableName() string {
 }
 
 type scrapperCommand struct {
-	threadId int
-	action   string
-	platform string
-	title    string
-	url      string
-	interval time.Duration
+	threadId   int
+	action     string
+	platform   string
+	title      string
+	url        string
+	interval   time.Duration
+	definition string
+	options    map[string]string
 }
 
 func (s scrapperCommand) Action() string {
@@ -74,76 +132,340 @@ func (s scrapperCommand) Url() string {
 	return s.url
 }
 
+func (s scrapperCommand) Options() map[string]string {
+	return s.options
+}
+
+func (u *Scrapper) Command() models.CommandSpec {
+	return models.CommandSpec{
+		Name:        "scrapper",
+		Aliases:     []string{"scraper"},
+		Description: "marketplace and real estate listings",
+		Actions: []models.ActionSpec{
+			{
+				Name:    "add",
+				Aliases: []string{"new", "subscribe"},
+				Help:    "scrap a search results page",
+				Args: []models.ArgSpec{
+					{Name: "platform", Help: strings.Join(slices.Sorted(maps.Keys(builtinDefinitions)), ", ") + " or a defined one, see definitions", Required: true},
+					{Name: "title", Help: "unique name of the subscription", Required: true},
+					{Name: "url", Help: "search results url", Required: true},
+					{Name: "interval", Help: "polling interval in minutes (min 60)", Required: true},
+				},
+				Flags: []models.ArgSpec{
+					{Name: minPriceOption, Help: "skip listings cheaper than this, e.g. 300000"},
+					{Name: maxPriceOption, Help: "skip listings more expensive than this, e.g. \"R$ 1.200.000\""},
+					{Name: pagesOption, Help: fmt.Sprintf("number of result pages to read, 1 (default) to %d", maxPages)},
+					{Name: detailsOption, Help: "true to read area, bedrooms, fees and photos from the page of each new listing"},
+					{Name: minAreaOption, Help: "skip listings smaller than this area"},
+					{Name: minBedroomsOption, Help: "skip listings with fewer bedrooms"},
+					{Name: minParkingOption, Help: "skip listings with fewer parking spaces"},
+					{Name: maxCondoFeeOption, Help: "skip listings with a higher condo fee"},
+					{Name: maxIptuOption, Help: "skip listings with a higher IPTU"},
+					{Name: goneAfterOption, Help: fmt.Sprintf("notify when a sent listing is missing from this many polls in a row and its page is removed, 1 to %d", maxGoneAfter)},
+					{Name: goneReplyOption, Help: "false to not reply to the listing message with the gone notice"},
+				},
+			},
+			{
+				Name:    "remove",
+				Aliases: []string{"rm", "delete", "unsubscribe"},
+				Help:    "remove a subscription",
+				Args: []models.ArgSpec{
+					{Name: "title", Required: true},
+				},
+			},
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Help:    "list subscriptions",
+			},
+			{
+				Name: "define",
+				Help: "add or replace a scraper definition, given as JSON or as an attached JSON file",
+				Args: []models.ArgSpec{
+					{Name: "definition", Help: `e.g. '{"name": "site", "item": "li.result", "fields": {"title": {"selector": "h2"}, "link": {"selector": "a", "attr": "href", "absolute": true}}}'`},
+				},
+			},
+			{
+				Name: "undefine",
+				Help: "remove a scraper definition",
+				Args: []models.ArgSpec{
+					{Name: "platform", Required: true},
+				},
+			},
+			{
+				Name: "definitions",
+				Help: "list the platforms that can be scraped",
+			},
+		},
+	}
+}
+
 func (u *Scrapper) ParseCommand(cmd models.Command) (models.Commander, error) {
-	s := strings.Split(cmd.Text, " ")
+	p, err := u.Command().Parse(cmd.Text)
+	if err != nil {
+		return nil, err
+	}
 
-	switch s[0] {
-	case "list":
-		return parseListCommand(cmd.ThreadId, s)
-	case "remove":
-		return parseRemoveCommand(cmd.ThreadId, s)
-	case "add":
-		return parseAddCommand(cmd.ThreadId, s)
+	interval, err := p.Interval("interval")
+	if err != nil {
+		return nil, fmt.Errorf("scrapper: %w", err)
 	}
 
-	return nil, fmt.Errorf("scrapper: invalid command")
-}
+	options := p.Options(
+		minPriceOption, maxPriceOption, pagesOption, detailsOption,
+		minAreaOption, minBedroomsOption, minParkingOption, maxCondoFeeOption, maxIptuOption,
+		goneAfterOption, goneReplyOption,
+	)
+	if err := parseFilterOptions(options); err != nil {
+		return nil, fmt.Errorf("scrapper: %w", err)
+	}
+	if err := parseCrawlOptions(options); err != nil {
+		return nil, fmt.Errorf("scrapper: %w", err)
+	}
+	if err := parseLifecycleOptions(options); err != nil {
+		return nil, fmt.Errorf("scrapper: %w", err)
+	}
 
-func parseListCommand(threadId int, s []string) (*scrapperCommand, error) {
 	return &scrapperCommand{
-		threadId: threadId,
-		action:   s[0],
+		threadId:   cmd.ThreadId,
+		action:     p.Action,
+		platform:   strings.ToLower(p.Get("platform")),
+		title:      p.Get("title"),
+		url:        p.Get("url"),
+		interval:   interval,
+		definition: p.Get("definition"),
+		options:    options,
 	}, nil
 }
 
-func parseRemoveCommand(threadId int, s []string) (*scrapperCommand, error) {
-	if len(s) < 2 {
-		return nil, fmt.Errorf("scrapper: invalid arguments")
+func (u *Scrapper) HandleAction(ctx context.Context, c models.Commander, attachment *models.File) (string, error) {
+	cmd, ok := c.(*scrapperCommand)
+	if !ok {
+		return "", fmt.Errorf("scrapper: unknown action %q", c.Action())
+	}
+	switch cmd.action {
+	case "define":
+		data := []byte(cmd.definition)
+		if len(data) == 0 && attachment != nil {
+			data = attachment.Data
+		}
+		if len(data) == 0 {
+			return "", fmt.Errorf("scrapper: give the definition as JSON or attach it")
+		}
+		def, err := u.define(ctx, data)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("scrapper: defined %s", def.Name), nil
+	case "undefine":
+		if err := u.undefine(ctx, cmd.platform); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("scrapper: removed definition %s", cmd.platform), nil
+	case "definitions":
+		names, err := u.definitionNames(ctx)
+		if err != nil {
+			return "", err
+		}
+		return "scrapper platforms: " + strings.Join(names, ", "), nil
 	}
+	return "", fmt.Errorf("scrapper: unknown action %q", cmd.action)
+}
 
-	return &scrapperCommand{
-		threadId: threadId,
-		action:   s[0],
-		title:    s[1],
-	}, nil
+func (u *Scrapper) Validate(ctx context.Context, sub *models.Subscription) error {
+	if u.clientErr != nil {
+		return u.clientErr
+	}
+	def, err := u.definition(ctx, sub.Platform)
+	if err != nil {
+		return err
+	}
+	if err := checkFilterSupport(def, sub); err != nil {
+		return fmt.Errorf("scrapper: %w", err)
+	}
+	if sub.Url == "" {
+		return fmt.Errorf("scrapper: url is required")
+	}
+
+	resp, err := u.client.req.R().SetContext(ctx).Get(sub.Url)
+	if err != nil {
+		return fmt.Errorf("scrapper: %s is not reachable: %w", sub.Url, err)
+	}
+	if resp.IsErrorState() {
+		return fmt.Errorf("scrapper: %s returned status %d", sub.Url, resp.StatusCode)
+	}
+	return nil
 }
 
-func parseAddCommand(threadId int, s []string) (*scrapperCommand, error) {
-	if len(s) < 5 {
-		return nil, fmt.Errorf("scrapper: invalid arguments")
+// Fetch scrapes the subscription page with the definition of its platform
+// and returns the items not seen before and the ones that got cheaper, along
+// with an alert when the page yields nothing usable, which usually means the
+// site markup changed, and notices for the sent items gone from the results.
+func (u *Scrapper) Fetch(ctx context.Context, sub *models.Subscription) ([]models.Content, error) {
+	if u.clientErr != nil {
+		return nil, u.clientErr
+	}
+	u.logger.Info("scrapping", zap.String("url", sub.Url), zap.String("platform", sub.Platform), zap.Int("threadId", sub.ThreadId))
+	def, err := u.definition(ctx, sub.Platform)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.client.jar.load(ctx, sub.Url); err != nil {
+		u.logger.Warn("failed to load cookies", zap.String("url", sub.Url), zap.Error(err))
 	}
+	defer func() {
+		if err := u.client.jar.save(ctx); err != nil {
+			u.logger.Warn("failed to save cookies", zap.Error(err))
+		}
+	}()
 
-	c := &scrapperCommand{
-		threadId: threadId,
-		action:   s[0],
-		platform: s[1],
-		title:    s[2],
-		url:      s[3],
+	crawl := subscriptionCrawl(sub)
+	res, err := u.scrap(u.client.collector(ctx), def, sub.Url, crawl.pages)
+	if err != nil {
+		return nil, err
 	}
 
-	interval, err := strconv.Atoi(s[4])
+	contents := make([]models.Content, 0, len(res.items))
+	breakage := res.breakage(def)
+	alert, err := u.checkBreakage(ctx, sub, breakage, res.matched == 0)
 	if err != nil {
-		return nil, fmt.Errorf("scrapper: invalid interval")
+		return nil, err
+	}
+	if alert != nil {
+		contents = append(contents, *alert)
+	}
+
+	filter := subscriptionFilter(sub)
+	details := detailsVisitor{def: def, c: u.client.collector(ctx), limit: maxDetailVisits}
+	var sent []item
+	for _, it := range res.items {
+		prev, err := u.trackedItem(ctx, sub, it.Link)
+		if err != nil {
+			return nil, err
+		}
+		if prev != nil {
+			it.mergeDetails(prev.item)
+		}
+		if crawl.details && def.Detail != nil && !it.Detailed {
+			if !details.visit(&it) {
+				// left untracked, so it is handled as new next time
+				continue
+			}
+		}
+
+		content, err := u.track(ctx, sub, filter, it, prev)
+		if err != nil {
+			return nil, err
+		}
+		if content != nil {
+			contents = append(contents, *content)
+		}
+		if filter.matches(it) {
+			sent = append(sent, it)
+		}
 	}
-	c.interval = time.Duration(interval) * time.Minute
 
-	if c.interval.Minutes() < 60 {
-		return nil, models.ErrInvalidIntervalDuration
+	// a broken page would make every listing look gone
+	if lifecycle := subscriptionLifecycle(sub); lifecycle.goneAfter > 0 && breakage == "" {
+		// the listings of a failed page would look gone too
+		gone, err := u.updateActive(ctx, sub, lifecycle, res.items, sent, !res.failed)
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, gone...)
 	}
+	return contents, nil
+}
 
-	return c, nil
+// scrapResult is what a page yielded.
+type scrapResult struct {
+	items []item
+	// matched is the number of elements matching the item selector,
+	// including the ones skipped for missing required fields.
+	matched int
+	// missing counts the skipped items by missing field.
+	missing map[string]int
+	// empty is set when a page shows the no results message of the
+	// definition.
+	empty bool
+	// failed is set when a page after the first one could not be read.
+	failed bool
 }
 
-func (u *Scrapper) Fetch(ctx context.Context, sub *models.Subscription) ([]models.Content, error) {
-	u.logger.Info("scrapping", zap.String("url", sub.Url), zap.Int("threadId", sub.ThreadId))
-	switch sub.Platform {
-	case OlxPlatform:
-		olxScrapper := NewOlx(u.logger.Named("olx"), u.db)
-		return olxScrapper.scrap(ctx, sub.ThreadId, sub.Url)
-	case ZapImoveisPlatform:
-		zapImoveisScrapper := NewZapImoveis(u.logger.Named("zap-imoveis"), u.db)
-		return zapImoveisScrapper.scrap(ctx, sub.ThreadId, sub.Url)
+// breakage describes why the result looks like the definition no longer
+// fits the page: no element matches the item selector, or most of them miss
+// required fields. It is empty for a healthy result, a search without
+// results included.
+func (r scrapResult) breakage(def *Definition) string {
+	if r.matched == 0 && r.empty {
+		return ""
+	}
+	if r.matched == 0 {
+		return fmt.Sprintf("no element matches the item selector %q", def.Item)
+	}
+	skipped := r.matched - len(r.items)
+	if skipped == 0 || len(r.items) > 0 && skipped*2 <= r.matched {
+		return ""
+	}
+	fields := ge.MKeys(r.missing)
+	slices.Sort(fields)
+	missing := ge.Map(fields, func(f string) string { return fmt.Sprintf("%s (%d)", f, r.missing[f]) })
+	return fmt.Sprintf("%d of %d items miss required fields: %s", skipped, r.matched, strings.Join(missing, ", "))
+}
+
+// checkBreakage returns an alert when the subscription breaks, or stops
+// being broken. The reason is kept so an alert is only sent once while the
+// page stays broken the same way. A reason to confirm, such as a page
+// without items that may just be a search without results, is only alerted
+// when the next poll finds it too.
+func (u *Scrapper) checkBreakage(ctx context.Context, sub *models.Subscription, reason string, confirm bool) (*models.Content, error) {
+	key := brokenKey(sub.Name)
+	previous, err := u.db.Get(ctx, key)
+	if err != nil && !u.db.IsErrNotFound(err) {
+		return nil, err
 	}
+	pending, isPending := strings.CutPrefix(string(previous), pendingBreakage)
+
+	switch {
+	case reason == "" && len(previous) == 0:
+		return nil, nil
+	case reason == "":
+		u.logger.Info("scrapper recovered", zap.String("name", sub.Name))
+		// the db cannot delete keys, an empty reason means healthy
+		if err := u.db.Set(ctx, key, nil, time.Minute); err != nil {
+			return nil, err
+		}
+		if isPending {
+			return nil, nil
+		}
+		return &models.Content{
+			ThreadId: sub.ThreadId,
+			Text:     fmt.Sprintf("✅ scrapper: %s is finding listings again", sub.Name),
+		}, nil
+	case reason == string(previous):
+		return nil, nil
+	case confirm && !(isPending && pending == reason):
+		u.logger.Info("scrapper page may be broken", zap.String("name", sub.Name), zap.String("reason", reason))
+		return nil, u.db.Set(ctx, key, []byte(pendingBreakage+reason), itemTTL)
+	}
+
+	u.logger.Warn("scrapper page looks broken", zap.String("name", sub.Name), zap.String("url", sub.Url), zap.String("reason", reason))
+	if err := u.db.Set(ctx, key, []byte(reason), itemTTL); err != nil {
+		return nil, err
+	}
+	return &models.Content{
+		ThreadId: sub.ThreadId,
+		Text: fmt.Sprintf(`⚠️ scrapper: %s found no usable listings, the %s page layout may have changed
+%s
+
+%s`, sub.Name, sub.Platform, reason, sub.Url),
+	}, nil
+}
+
+// pendingBreakage prefixes the kept reason of a breakage to confirm.
+const pendingBreakage = "pending: "
 
-	return nil, fmt.Errorf("scrapper: invalid platform")
+func brokenKey(name string) string {
+	return fmt.Sprintf("%s:%s", "scrapper:broken", strings.ToLower(name))
 }
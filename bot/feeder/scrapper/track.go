@@ -0,0 +1,95 @@
+package scrapper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/camopy/rss_everything/bot/models"
+)
+
+// trackedItem is what is kept of an item between polls.
+type trackedItem struct {
+	item
+	// Filtered items were seen out of the price range of the subscription
+	// and not sent yet.
+	Filtered bool `json:"filtered,omitempty"`
+}
+
+// track compares the item with prev, the last time the subscription saw it,
+// and returns the message to send, if any: the item when it is new or enters
+// the filters, or a price drop notice when it got cheaper. The item is saved
+// again on every poll, so it stays tracked for as long as it is listed.
+func (u *Scrapper) track(ctx context.Context, sub *models.Subscription, filter itemFilter, it item, prev *trackedItem) (*models.Content, error) {
+	matches := filter.matches(it)
+	sent := prev != nil && !prev.Filtered
+
+	var content *models.Content
+	switch {
+	case !sent && matches:
+		u.logger.Info("saved new item", zap.String("item", it.String()))
+		c := it.content(sub.ThreadId)
+		// gone notices reply to it
+		c.Key = itemKey(sub.Name, it.Link)
+		content = &c
+	case sent && matches:
+		if from, to, ok := priceDrop(prev.item, it); ok {
+			u.logger.Info("price dropped", zap.String("link", it.Link), zap.String("from", prev.Price), zap.String("to", it.Price))
+			c := it.content(sub.ThreadId)
+			c.Text = fmt.Sprintf("📉 price dropped from %s to %s (%.1f%%)\n\n%s", prev.Price, it.Price, from.percentChange(to), c.Text)
+			content = &c
+		}
+	}
+
+	value, err := json.Marshal(trackedItem{item: it, Filtered: !sent && !matches})
+	if err != nil {
+		return nil, err
+	}
+	if err := u.db.Set(ctx, itemKey(sub.Name, it.Link), value, itemTTL); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// trackedItem returns the item as last seen by the subscription, or nil.
+// Items sent before they were tracked per subscription are only marked as
+// seen under the shared key.
+func (u *Scrapper) trackedItem(ctx context.Context, sub *models.Subscription, link string) (*trackedItem, error) {
+	for _, key := range []string{itemKey(sub.Name, link), legacyItemKey(link)} {
+		value, err := u.db.Get(ctx, key)
+		if err != nil && !u.db.IsErrNotFound(err) {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		var t trackedItem
+		if err := json.Unmarshal(value, &t); err != nil {
+			return nil, err
+		}
+		return &t, nil
+	}
+	return nil, nil
+}
+
+// priceDrop reports whether the item got cheaper since prev, in the same
+// currency.
+func priceDrop(prev, it item) (from, to price, ok bool) {
+	from, okFrom := parsePrice(prev.Price)
+	to, okTo := parsePrice(it.Price)
+	if !okFrom || !okTo || from.Currency != to.Currency {
+		return price{}, price{}, false
+	}
+	return from, to, to.Cents < from.Cents
+}
+
+func itemKey(subName, link string) string {
+	return fmt.Sprintf("scrapper:%s:items:%s", strings.ToLower(subName), link)
+}
+
+func legacyItemKey(link string) string {
+	return fmt.Sprintf("%s:%s", "scrapper:items", link)
+}
@@ -0,0 +1,45 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/camopy/rss_everything/bot/models"
+	"github.com/camopy/rss_everything/db"
+)
+
+const (
+	sentMessagesTable = "messages:"
+	// sentMessageTTL is how long a content with a key can be replied to.
+	sentMessageTTL = 60 * 24 * time.Hour
+)
+
+// sentMessages remembers the message each content with a key was sent as,
+// so later contents can reply to it.
+type sentMessages struct {
+	db db.DB
+}
+
+// save records the message id of c, when it has a key.
+func (s sentMessages) save(ctx context.Context, c models.Content, id string) error {
+	if c.Key == "" || id == "" {
+		return nil
+	}
+	return s.db.Set(ctx, sentMessagesTable+c.Key, []byte(id), sentMessageTTL)
+}
+
+// replyTo returns the message id c replies to, empty when c is not a reply
+// or the message is unknown.
+func (s sentMessages) replyTo(ctx context.Context, c models.Content) (string, error) {
+	if c.ReplyTo == "" {
+		return "", nil
+	}
+	id, err := s.db.Get(ctx, sentMessagesTable+c.ReplyTo)
+	if err != nil {
+		if s.db.IsErrNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(id), nil
+}
@@ -5,6 +5,9 @@ import (
 	"time"
 )
 
+// MinInterval is the shortest polling interval of a subscription.
+const MinInterval = 60 * time.Minute
+
 var ErrInvalidIntervalDuration = fmt.Errorf("interval must be at least 60 minutes")
 
 type Commander interface {
@@ -14,6 +17,7 @@ type Commander interface {
 	SubName() string
 	Platform() string
 	Url() string
+	Options() map[string]string
 }
 
 type Command struct {
@@ -21,9 +25,44 @@ type Command struct {
 	ChatId   int64
 	ThreadId int
 	Text     string
+	// Attachment is a file sent along with the command, e.g. an OPML import.
+	Attachment *File
 }
 
 type Content struct {
 	Text     string
 	ThreadId int
+	// File is sent as a document, with Text as its caption.
+	File *File
+	// Media is sent as photos or audio, with Text as the caption.
+	Media []Media
+	// Key identifies the content, so later contents can reply to the
+	// message it was sent as.
+	Key string
+	// ReplyTo is the key of an earlier content this one replies to. The
+	// content is sent on its own when that message is unknown.
+	ReplyTo string
+}
+
+type MediaType string
+
+const (
+	MediaPhoto MediaType = "photo"
+	MediaAudio MediaType = "audio"
+)
+
+// Media is a photo or audio attached to a content. It is either uploaded
+// from File or sent by Url.
+type Media struct {
+	Type      MediaType
+	Url       string
+	File      *File
+	Title     string
+	Performer string
+	Duration  time.Duration
+}
+
+type File struct {
+	Name string
+	Data []byte
 }
@@ -0,0 +1,400 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	ge "github.com/camopy/rss_everything/util/generics"
+)
+
+var ErrInvalidInterval = fmt.Errorf("interval must be a number of minutes or a duration like 2h")
+
+var flagKeyRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
+var helpActions = []string{"help", "?"}
+
+// CommandSpec describes a bot command such as /rss, its actions and their
+// arguments. It is used both to parse the command text and to generate help.
+type CommandSpec struct {
+	Name        string
+	Aliases     []string
+	Description string
+	Actions     []ActionSpec
+}
+
+type ActionSpec struct {
+	Name    string
+	Aliases []string
+	Help    string
+	// Args are filled in order by positional arguments, or by name with key=value.
+	Args []ArgSpec
+	// Flags can only be given as key=value.
+	Flags []ArgSpec
+}
+
+type ArgSpec struct {
+	Name     string
+	Help     string
+	Required bool
+	// Match, when set on an optional argument, skips it for positional values
+	// it does not accept, leaving them to the following arguments.
+	Match func(string) bool
+	// Variadic collects all remaining positional values, separated by spaces.
+	// Only the last argument can be variadic.
+	Variadic bool
+	// Secret values, such as passwords, are redacted from logs and errors.
+	Secret bool
+}
+
+// ParsedCommand holds the resolved action and the named argument values.
+type ParsedCommand struct {
+	Action string
+	values map[string]string
+}
+
+func (p ParsedCommand) Get(name string) string {
+	return p.values[name]
+}
+
+func (p ParsedCommand) Has(name string) bool {
+	_, ok := p.values[name]
+	return ok
+}
+
+// Options returns the values of the given arguments that were set.
+func (p ParsedCommand) Options(names ...string) map[string]string {
+	var opts map[string]string
+	for _, name := range names {
+		if !p.Has(name) {
+			continue
+		}
+		if opts == nil {
+			opts = make(map[string]string)
+		}
+		opts[name] = p.Get(name)
+	}
+	return opts
+}
+
+// Interval returns the named argument as a polling interval, or zero if it is unset.
+func (p ParsedCommand) Interval(name string) (time.Duration, error) {
+	if !p.Has(name) {
+		return 0, nil
+	}
+	return ParseInterval(p.Get(name))
+}
+
+// SplitCommand splits "/name@bot rest of text" into the lower-cased command
+// name without the bot mention and the remaining text. It reports false when
+// the text is not a command.
+func SplitCommand(text string) (name string, args string, ok bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return "", "", false
+	}
+	name, args, _ = strings.Cut(text, " ")
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	if len(name) < 2 {
+		return "", "", false
+	}
+	return strings.ToLower(name), strings.TrimSpace(args), true
+}
+
+// Matches reports whether the command name, as returned by SplitCommand,
+// refers to this spec.
+func (s CommandSpec) Matches(name string) bool {
+	name = strings.TrimPrefix(strings.ToLower(name), "/")
+	if name == s.Name {
+		return true
+	}
+	for _, alias := range s.Aliases {
+		if name == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHelp reports whether the command text asks for help, which is also the
+// case when no action is given.
+func (s CommandSpec) IsHelp(text string) bool {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return false
+	}
+	if len(tokens) == 0 {
+		return true
+	}
+	for _, h := range helpActions {
+		if strings.EqualFold(tokens[0].value, h) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s CommandSpec) Parse(text string) (ParsedCommand, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return ParsedCommand{}, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	if len(tokens) == 0 {
+		return ParsedCommand{}, fmt.Errorf("%s: missing action, try /%s help", s.Name, s.Name)
+	}
+
+	action := s.findAction(tokens[0].value)
+	if action == nil {
+		return ParsedCommand{}, fmt.Errorf("%s: unknown action %q, try /%s help", s.Name, Redact(tokens[0].value, s.Secrets()), s.Name)
+	}
+
+	p := ParsedCommand{
+		Action: action.Name,
+		values: make(map[string]string),
+	}
+	var positional []string
+	for _, t := range tokens[1:] {
+		key, value, isFlag := t.flag()
+		if !isFlag {
+			positional = append(positional, t.value)
+			continue
+		}
+		if action.findArg(key) == nil {
+			return ParsedCommand{}, fmt.Errorf("%s %s: unknown flag %q", s.Name, action.Name, key)
+		}
+		p.values[key] = value
+	}
+
+	// Optional arguments are skipped when the remaining positional values are
+	// only enough for the required ones, so "add foo https://..." leaves
+	// interval unset instead of treating the url as the interval.
+	for i, arg := range action.Args {
+		if len(positional) == 0 {
+			break
+		}
+		if p.Has(arg.Name) {
+			continue
+		}
+		if !arg.Required && len(positional) <= action.missingRequired(p, i+1) {
+			continue
+		}
+		if !arg.Required && arg.Match != nil && !arg.Match(positional[0]) {
+			continue
+		}
+		if arg.Variadic {
+			p.values[arg.Name] = strings.Join(positional, " ")
+			positional = nil
+			break
+		}
+		p.values[arg.Name] = positional[0]
+		positional = positional[1:]
+	}
+	if len(positional) > 0 {
+		return ParsedCommand{}, fmt.Errorf("%s %s: too many arguments: %s", s.Name, action.Name, Redact(strings.Join(positional, " "), s.Secrets()))
+	}
+
+	for _, arg := range action.Args {
+		if arg.Required && p.Get(arg.Name) == "" {
+			return ParsedCommand{}, fmt.Errorf("%s %s: missing argument %s, usage: %s", s.Name, action.Name, arg.Name, s.usage(*action))
+		}
+	}
+	return p, nil
+}
+
+func (s CommandSpec) findAction(name string) *ActionSpec {
+	name = strings.ToLower(name)
+	for i := range s.Actions {
+		a := &s.Actions[i]
+		if a.Name == name {
+			return a
+		}
+		for _, alias := range a.Aliases {
+			if alias == name {
+				return a
+			}
+		}
+	}
+	return nil
+}
+
+func (a ActionSpec) missingRequired(p ParsedCommand, from int) int {
+	n := 0
+	for _, arg := range a.Args[from:] {
+		if arg.Required && !p.Has(arg.Name) {
+			n++
+		}
+	}
+	return n
+}
+
+func (a ActionSpec) findArg(name string) *ArgSpec {
+	for i := range a.Args {
+		if a.Args[i].Name == name {
+			return &a.Args[i]
+		}
+	}
+	for i := range a.Flags {
+		if a.Flags[i].Name == name {
+			return &a.Flags[i]
+		}
+	}
+	return nil
+}
+
+// Secrets returns the names of the secret arguments of every action.
+func (s CommandSpec) Secrets() []string {
+	var names []string
+	for _, a := range s.Actions {
+		for _, arg := range ge.Flatten(a.Args, a.Flags) {
+			if arg.Secret && !slices.Contains(names, arg.Name) {
+				names = append(names, arg.Name)
+			}
+		}
+	}
+	return names
+}
+
+// Redact replaces the values given as key=value to the named arguments in
+// text with ***, so commands with credentials can be logged.
+func Redact(text string, names []string) string {
+	if len(names) == 0 {
+		return text
+	}
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+	re := regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)=("[^"]*"?|'[^']*'?|“[^”]*”?|\S*)`)
+	return re.ReplaceAllString(text, "$1=***")
+}
+
+// Help renders the usage of every action of the command.
+func (s CommandSpec) Help() string {
+	var b strings.Builder
+	b.WriteString("/" + s.Name)
+	if len(s.Aliases) > 0 {
+		b.WriteString(" (/" + strings.Join(s.Aliases, ", /") + ")")
+	}
+	if s.Description != "" {
+		b.WriteString(" - " + s.Description)
+	}
+	b.WriteString("\n")
+	for _, a := range s.Actions {
+		b.WriteString("  " + s.usage(a))
+		if a.Help != "" {
+			b.WriteString(" - " + a.Help)
+		}
+		b.WriteString("\n")
+		for _, arg := range ge.Flatten(a.Args, a.Flags) {
+			if arg.Help != "" {
+				b.WriteString(fmt.Sprintf("      %s: %s\n", arg.Name, arg.Help))
+			}
+		}
+	}
+	return b.String()
+}
+
+func (s CommandSpec) usage(a ActionSpec) string {
+	parts := []string{"/" + s.Name, a.Name}
+	for _, arg := range a.Args {
+		name := ge.Cond(arg.Variadic, arg.Name+"...", arg.Name)
+		parts = append(parts, ge.Cond(arg.Required, "<"+name+">", "["+name+"]"))
+	}
+	for _, f := range a.Flags {
+		parts = append(parts, "["+f.Name+"=...]")
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseInterval parses a polling interval given in minutes ("90") or as a
+// duration ("2h").
+func ParseInterval(s string) (time.Duration, error) {
+	var interval time.Duration
+	if minutes, err := strconv.Atoi(s); err == nil {
+		interval = time.Duration(minutes) * time.Minute
+	} else if d, err := time.ParseDuration(s); err == nil {
+		interval = d
+	} else {
+		return 0, ErrInvalidInterval
+	}
+	if interval < MinInterval {
+		return 0, ErrInvalidIntervalDuration
+	}
+	return interval, nil
+}
+
+// LooksLikeInterval reports whether s is meant as an interval, a number of
+// minutes or a duration, even if it is not a valid one.
+func LooksLikeInterval(s string) bool {
+	if _, err := strconv.Atoi(s); err == nil {
+		return true
+	}
+	_, err := time.ParseDuration(s)
+	return err == nil
+}
+
+type token struct {
+	value  string
+	quoted bool
+}
+
+// flag reports whether the token is an unquoted key=value pair.
+func (t token) flag() (key, value string, ok bool) {
+	if t.quoted {
+		return "", "", false
+	}
+	key, value, ok = strings.Cut(t.value, "=")
+	if !ok || !flagKeyRe.MatchString(key) {
+		return "", "", false
+	}
+	return strings.ToLower(key), value, true
+}
+
+// tokenize splits text on whitespace, keeping "double" or 'single' quoted
+// sections together. Quotes only open at the start of a token or right after
+// key=, as in title="My Feed", so apostrophes inside words are kept as is.
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+	var cur strings.Builder
+	inToken := false
+	quoted := false
+	var quote rune
+
+	for _, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case (r == '"' || r == '\'' || r == '“' || r == '”') && (!inToken || strings.HasSuffix(cur.String(), "=")):
+			quote = ge.Cond(r == '“', '”', r)
+			if !inToken {
+				quoted = true
+			}
+			inToken = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inToken {
+				tokens = append(tokens, token{value: cur.String(), quoted: quoted})
+				cur.Reset()
+				inToken, quoted = false, false
+			}
+		default:
+			cur.WriteRune(r)
+			inToken = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inToken {
+		tokens = append(tokens, token{value: cur.String(), quoted: quoted})
+	}
+	return tokens, nil
+}
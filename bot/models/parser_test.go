@@ -0,0 +1,194 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+var testSpec = CommandSpec{
+	Name:    "rss",
+	Aliases: []string{"feed"},
+	Actions: []ActionSpec{
+		{
+			Name:    "add",
+			Aliases: []string{"new"},
+			Args: []ArgSpec{
+				{Name: "title", Required: true},
+				{Name: "interval"},
+				{Name: "url", Required: true},
+			},
+			Flags: []ArgSpec{
+				{Name: "excerpt"},
+				{Name: "password", Secret: true},
+			},
+		},
+		{
+			Name: "list",
+		},
+	},
+}
+
+func TestSplitCommand(t *testing.T) {
+	t.Run("plain", func(t *testing.T) {
+		name, args, ok := SplitCommand("/rss add foo")
+		assert.True(t, ok)
+		assert.Equal(t, "/rss", name)
+		assert.Equal(t, "add foo", args)
+	})
+
+	t.Run("bot mention", func(t *testing.T) {
+		name, args, ok := SplitCommand("/RSS@MyBot   list")
+		assert.True(t, ok)
+		assert.Equal(t, "/rss", name)
+		assert.Equal(t, "list", args)
+	})
+
+	t.Run("no arguments", func(t *testing.T) {
+		name, args, ok := SplitCommand("/help")
+		assert.True(t, ok)
+		assert.Equal(t, "/help", name)
+		assert.Empty(t, args)
+	})
+
+	t.Run("not a command", func(t *testing.T) {
+		_, _, ok := SplitCommand("hello")
+		assert.False(t, ok)
+		_, _, ok = SplitCommand("/")
+		assert.False(t, ok)
+	})
+}
+
+func TestCommandSpecParse(t *testing.T) {
+	t.Run("positional", func(t *testing.T) {
+		p, err := testSpec.Parse("add  foo 120   https://example.com/feed")
+		assert.NoError(t, err)
+		assert.Equal(t, "add", p.Action)
+		assert.Equal(t, "foo", p.Get("title"))
+		assert.Equal(t, "https://example.com/feed", p.Get("url"))
+		interval, err := p.Interval("interval")
+		assert.NoError(t, err)
+		assert.Equal(t, 2*time.Hour, interval)
+	})
+
+	t.Run("quotes and flags", func(t *testing.T) {
+		p, err := testSpec.Parse(`new "Joe's Blog" url=https://example.com/?a=b excerpt=200`)
+		assert.NoError(t, err)
+		assert.Equal(t, "add", p.Action)
+		assert.Equal(t, "Joe's Blog", p.Get("title"))
+		assert.Equal(t, "https://example.com/?a=b", p.Get("url"))
+		assert.Equal(t, "200", p.Get("excerpt"))
+		assert.False(t, p.Has("interval"))
+	})
+
+	t.Run("quoted flag value", func(t *testing.T) {
+		p, err := testSpec.Parse(`add title="My Feed" https://example.com/feed`)
+		assert.NoError(t, err)
+		assert.Equal(t, "My Feed", p.Get("title"))
+		assert.Equal(t, "https://example.com/feed", p.Get("url"))
+		assert.False(t, p.Has("interval"))
+	})
+
+	t.Run("apostrophe inside word", func(t *testing.T) {
+		p, err := testSpec.Parse(`add joe's 60 https://example.com/feed`)
+		assert.NoError(t, err)
+		assert.Equal(t, "joe's", p.Get("title"))
+	})
+
+	t.Run("missing argument", func(t *testing.T) {
+		_, err := testSpec.Parse("add foo")
+		assert.ErrorContains(t, err, "missing argument url")
+	})
+
+	t.Run("unknown action", func(t *testing.T) {
+		_, err := testSpec.Parse("edit foo")
+		assert.ErrorContains(t, err, "unknown action")
+	})
+
+	t.Run("unknown flag", func(t *testing.T) {
+		_, err := testSpec.Parse("add foo url=x color=red")
+		assert.ErrorContains(t, err, "unknown flag")
+	})
+
+	t.Run("too many arguments", func(t *testing.T) {
+		_, err := testSpec.Parse("list foo")
+		assert.ErrorContains(t, err, "too many arguments")
+	})
+
+	t.Run("unterminated quote", func(t *testing.T) {
+		_, err := testSpec.Parse(`add "foo`)
+		assert.Error(t, err)
+	})
+
+	t.Run("matched and variadic arguments", func(t *testing.T) {
+		spec := CommandSpec{
+			Name: "hn",
+			Actions: []ActionSpec{
+				{
+					Name: "add",
+					Args: []ArgSpec{
+						{Name: "name", Required: true},
+						{Name: "interval", Match: LooksLikeInterval},
+						{Name: "list"},
+						{Name: "filters", Variadic: true},
+					},
+				},
+			},
+		}
+
+		p, err := spec.Parse("add popular show score>=100 comments>=10")
+		assert.NoError(t, err)
+		assert.False(t, p.Has("interval"))
+		assert.Equal(t, "show", p.Get("list"))
+		assert.Equal(t, "score>=100 comments>=10", p.Get("filters"))
+
+		p, err = spec.Parse("add popular 2h")
+		assert.NoError(t, err)
+		assert.Equal(t, "2h", p.Get("interval"))
+		assert.False(t, p.Has("list"))
+	})
+}
+
+func TestCommandSpecHelp(t *testing.T) {
+	assert.True(t, testSpec.IsHelp(""))
+	assert.True(t, testSpec.IsHelp("help"))
+	assert.False(t, testSpec.IsHelp("list"))
+	assert.True(t, testSpec.Matches("/feed"))
+	assert.False(t, testSpec.Matches("/reddit"))
+
+	help := testSpec.Help()
+	assert.Contains(t, help, "/rss add <title> [interval] <url> [excerpt=...]")
+	assert.Contains(t, help, "/rss list")
+}
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, []string{"password"}, testSpec.Secrets())
+	assert.Equal(t,
+		"/rss add blog https://blog.example password=*** excerpt=true",
+		Redact("/rss add blog https://blog.example password=hunter2 excerpt=true", testSpec.Secrets()),
+	)
+	assert.Equal(t, "add blog Password=*** url", Redact(`add blog Password="two words" url`, testSpec.Secrets()))
+	assert.Equal(t, "add blog", Redact("add blog", nil))
+
+	_, err := testSpec.Parse("password=hunter2 blog")
+	assert.ErrorContains(t, err, `unknown action "password=***"`)
+	_, err = testSpec.Parse(`add blog 120 https://blog.example "password=hunter2"`)
+	assert.ErrorContains(t, err, "too many arguments: password=***")
+}
+
+func TestParseInterval(t *testing.T) {
+	d, err := ParseInterval("90")
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, d)
+
+	d, err = ParseInterval("2h")
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, d)
+
+	_, err = ParseInterval("30")
+	assert.ErrorIs(t, err, ErrInvalidIntervalDuration)
+
+	_, err = ParseInterval("soon")
+	assert.ErrorIs(t, err, ErrInvalidInterval)
+}
@@ -12,6 +12,8 @@ type Subscription struct {
 	ThreadId int           `json:"thread_id"`
 	Platform string        `json:"platform"`
 	Url      string        `json:"url"`
+	// Options holds feeder specific settings given as flags on add.
+	Options map[string]string `json:"options,omitempty"`
 
 	CancelFunc context.CancelFunc `json:"-"`
 }
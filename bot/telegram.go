@@ -1,10 +1,12 @@
 package bot
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"strings"
+	"strconv"
 	"time"
+	"unicode/utf8"
 
 	"github.com/avast/retry-go/v4"
 	"github.com/go-telegram/bot"
@@ -18,13 +20,19 @@ import (
 	"github.com/camopy/rss_everything/bot/feeder/scrapper"
 	"github.com/camopy/rss_everything/bot/models"
 	"github.com/camopy/rss_everything/db"
+	ge "github.com/camopy/rss_everything/util/generics"
 	"github.com/camopy/rss_everything/util/psub"
 	"github.com/camopy/rss_everything/util/run"
+	"github.com/camopy/rss_everything/websub"
 	"github.com/camopy/rss_everything/zaplog"
 )
 
 const (
 	maxRetries = 4
+	// maxCaptionLength is the Telegram limit for media captions.
+	maxCaptionLength = 1024
+	// maxMediaGroupSize is the Telegram limit of photos in an album.
+	maxMediaGroupSize = 10
 )
 
 type TelegramConfig struct {
@@ -34,6 +42,17 @@ type TelegramConfig struct {
 	RedditApiKey   string
 	RedditUsername string
 	RedditPassword string
+	// WebSubCallbackUrl enables WebSub push for RSS feeds when set.
+	WebSubCallbackUrl string
+	WebSubAddr        string
+	// RssSecretKey encrypts the credentials of RSS subscriptions.
+	RssSecretKey string
+	// ScrapperDefinitions is the path of a JSON file with scraper
+	// definitions, optional.
+	ScrapperDefinitions string
+	// ScrapperClient sets the rate limits, robots.txt policy and proxies of
+	// the scrapper.
+	ScrapperClient scrapper.ClientConfig
 }
 
 type Telegram struct {
@@ -108,31 +127,9 @@ func (b *Telegram) Start(ctx run.Context) error {
 func (b *Telegram) handleContentUpdates(ctx context.Context) error {
 	return psub.ProcessWithContext(ctx, b.contentSubscriber.Subscribe(ctx), func(ctx context.Context, contents []models.Content) error {
 		for _, c := range contents {
-			attempt := 0
-			err := retry.Do(
-				func() error {
-					_, err := b.client.SendMessage(ctx, &bot.SendMessageParams{
-						ChatID:          b.cfg.ChatId,
-						Text:            c.Text,
-						MessageThreadID: c.ThreadId,
-					})
-					return err
-				},
-				retry.RetryIf(bot.IsTooManyRequestsError),
-				retry.LastErrorOnly(true),
-				retry.Context(ctx),
-				retry.Attempts(maxRetries),
-				retry.DelayType(func(n uint, err error, config *retry.Config) time.Duration {
-					if bot.IsTooManyRequestsError(err) {
-						return time.Duration(err.(*bot.TooManyRequestsError).RetryAfter) * time.Second
-					}
-					return retry.BackOffDelay(n, err, config)
-				}),
-				retry.OnRetry(func(n uint, err error) {
-					attempt++
-					b.logger.Warn(fmt.Sprintf("failed to send content update to telegram, retrying..."), zap.Error(err), zap.Uint("attempt", n))
-				}),
-			)
+			err := b.retry(ctx, func() error {
+				return b.send(ctx, c)
+			})
 			if err != nil {
 				b.logger.Error(fmt.Sprintf("failed to send content update to telegram: %v", err))
 			}
@@ -141,12 +138,186 @@ func (b *Telegram) handleContentUpdates(ctx context.Context) error {
 	})
 }
 
+// retry calls send until it succeeds or fails for a reason other than the
+// Telegram rate limit, waiting as long as Telegram asks to.
+func (b *Telegram) retry(ctx context.Context, send func() error) error {
+	return retry.Do(
+		send,
+		retry.RetryIf(bot.IsTooManyRequestsError),
+		retry.LastErrorOnly(true),
+		retry.Context(ctx),
+		retry.Attempts(maxRetries),
+		retry.DelayType(func(n uint, err error, config *retry.Config) time.Duration {
+			if bot.IsTooManyRequestsError(err) {
+				return time.Duration(err.(*bot.TooManyRequestsError).RetryAfter) * time.Second
+			}
+			return retry.BackOffDelay(n, err, config)
+		}),
+		retry.OnRetry(func(n uint, err error) {
+			b.logger.Warn(fmt.Sprintf("failed to send content update to telegram, retrying..."), zap.Error(err), zap.Uint("attempt", n))
+		}),
+	)
+}
+
+// send posts c, as a reply when it replies to a known message, and records
+// the message it was sent as when it has a key.
+func (b *Telegram) send(ctx context.Context, c models.Content) error {
+	messages := sentMessages{db: b.db}
+	var reply *tmodels.ReplyParameters
+	replyTo, err := messages.replyTo(ctx, c)
+	if err != nil {
+		b.logger.Warn("failed to read the message to reply to", zap.String("key", c.ReplyTo), zap.Error(err))
+	}
+	if id, err := strconv.Atoi(replyTo); err == nil {
+		reply = &tmodels.ReplyParameters{MessageID: id, AllowSendingWithoutReply: true}
+	}
+
+	msg, err := b.post(ctx, c, reply)
+	if err != nil {
+		return err
+	}
+	if msg != nil {
+		if err := messages.save(ctx, c, strconv.Itoa(msg.ID)); err != nil {
+			b.logger.Warn("failed to save sent message", zap.String("key", c.Key), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (b *Telegram) post(ctx context.Context, c models.Content, reply *tmodels.ReplyParameters) (*tmodels.Message, error) {
+	switch {
+	case c.File != nil:
+		return b.client.SendDocument(ctx, &bot.SendDocumentParams{
+			ChatID:          b.cfg.ChatId,
+			MessageThreadID: c.ThreadId,
+			Document: &tmodels.InputFileUpload{
+				Filename: c.File.Name,
+				Data:     bytes.NewReader(c.File.Data),
+			},
+			Caption:         c.Text,
+			ReplyParameters: reply,
+		})
+	case len(c.Media) > 0:
+		return b.sendMedia(ctx, c, reply)
+	}
+	return b.client.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:          b.cfg.ChatId,
+		Text:            c.Text,
+		MessageThreadID: c.ThreadId,
+		ReplyParameters: reply,
+	})
+}
+
+// sendMedia sends the first media of c, or its photos as an album, with its
+// text as caption. Texts over the caption limit are sent as a separate
+// message, and media Telegram refuses falls back to the text alone. The
+// first message sent is returned, and once it is sent sendMedia succeeds.
+func (b *Telegram) sendMedia(ctx context.Context, c models.Content, reply *tmodels.ReplyParameters) (*tmodels.Message, error) {
+	caption, text := c.Text, ""
+	if utf8.RuneCountInString(caption) > maxCaptionLength {
+		caption, text = "", c.Text
+	}
+
+	var (
+		msg *tmodels.Message
+		err error
+	)
+	photos := ge.Filter(c.Media, func(m models.Media) bool { return m.Type == models.MediaPhoto })
+	switch m := c.Media[0]; {
+	case m.Type == models.MediaPhoto && len(photos) > 1:
+		group := make([]tmodels.InputMedia, 0, maxMediaGroupSize)
+		for i, photo := range photos[:min(len(photos), maxMediaGroupSize)] {
+			group = append(group, telegramInputPhoto(photo, ge.Cond(i == 0, caption, "")))
+		}
+		var msgs []*tmodels.Message
+		msgs, err = b.client.SendMediaGroup(ctx, &bot.SendMediaGroupParams{
+			ChatID:          b.cfg.ChatId,
+			MessageThreadID: c.ThreadId,
+			Media:           group,
+			ReplyParameters: reply,
+		})
+		if len(msgs) > 0 {
+			msg = msgs[0]
+		}
+	case m.Type == models.MediaAudio:
+		msg, err = b.client.SendAudio(ctx, &bot.SendAudioParams{
+			ChatID:          b.cfg.ChatId,
+			MessageThreadID: c.ThreadId,
+			Audio:           telegramInputFile(m),
+			Caption:         caption,
+			Duration:        int(m.Duration.Seconds()),
+			Performer:       m.Performer,
+			Title:           m.Title,
+			ReplyParameters: reply,
+		})
+	default:
+		msg, err = b.client.SendPhoto(ctx, &bot.SendPhotoParams{
+			ChatID:          b.cfg.ChatId,
+			MessageThreadID: c.ThreadId,
+			Photo:           telegramInputFile(m),
+			Caption:         caption,
+			ReplyParameters: reply,
+		})
+	}
+	if err != nil {
+		if bot.IsTooManyRequestsError(err) {
+			return nil, err
+		}
+		b.logger.Warn("failed to send media to telegram, sending text only", zap.Error(err))
+		text = c.Text
+	}
+	if text == "" {
+		return msg, nil
+	}
+	params := &bot.SendMessageParams{
+		ChatID:          b.cfg.ChatId,
+		Text:            text,
+		MessageThreadID: c.ThreadId,
+		ReplyParameters: ge.Cond(msg == nil, reply, nil),
+	}
+	if msg == nil {
+		return b.client.SendMessage(ctx, params)
+	}
+	// The media went out, so only the text is retried: retrying the whole
+	// content would send the media again.
+	err = b.retry(ctx, func() error {
+		_, err := b.client.SendMessage(ctx, params)
+		return err
+	})
+	if err != nil {
+		b.logger.Warn("failed to send the text of a media to telegram", zap.Error(err))
+	}
+	return msg, nil
+}
+
+func telegramInputFile(m models.Media) tmodels.InputFile {
+	if m.File != nil {
+		return &tmodels.InputFileUpload{
+			Filename: m.File.Name,
+			Data:     bytes.NewReader(m.File.Data),
+		}
+	}
+	return &tmodels.InputFileString{Data: m.Url}
+}
+
+func telegramInputPhoto(m models.Media, caption string) *tmodels.InputMediaPhoto {
+	if m.File != nil {
+		return &tmodels.InputMediaPhoto{
+			Media:           "attach://" + m.File.Name,
+			MediaAttachment: bytes.NewReader(m.File.Data),
+			Caption:         caption,
+		}
+	}
+	return &tmodels.InputMediaPhoto{Media: m.Url, Caption: caption}
+}
+
 func (b *Telegram) handleMessages(ctx context.Context) error {
 	isCommand := func(m *tmodels.Message) bool {
-		if m.Entities == nil || len(m.Entities) == 0 {
+		entities := ge.Cond(len(m.Entities) > 0, m.Entities, m.CaptionEntities)
+		if len(entities) == 0 {
 			return false
 		}
-		entity := m.Entities[0]
+		entity := entities[0]
 		return entity.Offset == 0 && entity.Type == "bot_command"
 	}
 
@@ -154,7 +325,7 @@ func (b *Telegram) handleMessages(ctx context.Context) error {
 		b.logger.Info(
 			"message received",
 			zap.Int("threadId", update.Message.MessageThreadID),
-			zap.String("msg", update.Message.Text),
+			zap.String("msg", redactCommand(b.feeds(), update.Message.Text)),
 		)
 		if !b.isValidChatId(update.Message.Chat.ID) {
 			b.logger.Info("invalid chat id", zap.Int64("chatId", update.Message.Chat.ID))
@@ -173,42 +344,57 @@ func (b *Telegram) isValidChatId(id int64) bool {
 }
 
 func (b *Telegram) handleCommand(ctx context.Context, update *tmodels.Update) {
-	b.logger.Info("command received", zap.String("cmd", update.Message.Text))
-	entity := update.Message.Entities[0]
+	text := ge.Cond(update.Message.Text != "", update.Message.Text, update.Message.Caption)
+	b.logger.Info("command received", zap.String("cmd", redactCommand(b.feeds(), text)))
+	name, args, ok := models.SplitCommand(text)
+	if !ok {
+		return
+	}
 	cmd := models.Command{
-		Name:     update.Message.Text[:entity.Length],
+		Name:     name,
 		ChatId:   update.Message.Chat.ID,
 		ThreadId: update.Message.MessageThreadID,
-		Text:     strings.Trim(update.Message.Text[entity.Length:], " "),
+		Text:     args,
 	}
 
-	switch cmd.Name {
-	case "/scrapper":
-		err := b.scrapper.HandleCommand(ctx, cmd)
-		if err != nil {
-			b.logger.Error("scrapper command failed", zap.Error(err))
-		}
-	case "/reddit":
-		err := b.reddit.HandleCommand(ctx, cmd)
-		if err != nil {
-			b.logger.Error("reddit command failed", zap.Error(err))
-		}
-	case "/rss":
-		err := b.rss.HandleCommand(ctx, cmd)
-		if err != nil {
-			b.logger.Error("rss command failed", zap.Error(err))
-		}
-	case "/hn":
-		err := b.hackerNews.HandleCommand(ctx, cmd)
+	if doc := update.Message.Document; doc != nil {
+		attachment, err := b.downloadDocument(ctx, doc)
 		if err != nil {
-			b.logger.Error("hacker news command failed", zap.Error(err))
+			b.logger.Error("failed to download attachment", zap.String("file", doc.FileName), zap.Error(err))
 		}
+		cmd.Attachment = attachment
 	}
+
+	dispatchCommand(ctx, b.logger, b.contentPublisher, b.feeds(), cmd)
+}
+
+func (b *Telegram) downloadDocument(ctx context.Context, doc *tmodels.Document) (*models.File, error) {
+	if doc.FileSize > maxAttachmentSize {
+		return nil, fmt.Errorf("file %s is larger than %d bytes", doc.FileName, maxAttachmentSize)
+	}
+	file, err := b.client.GetFile(ctx, &bot.GetFileParams{FileID: doc.FileID})
+	if err != nil {
+		return nil, err
+	}
+	return downloadFile(ctx, doc.FileName, b.client.FileDownloadLink(file))
+}
+
+func (b *Telegram) feeds() []*feeder.Feed {
+	return []*feeder.Feed{b.hackerNews, b.reddit, b.rss, b.scrapper}
 }
 
 func (b *Telegram) initFeeds(ctx run.Context, cfg TelegramConfig) {
 	logger := b.logger.Named("feeds")
 
+	var push *websub.Subscriber
+	if cfg.WebSubCallbackUrl != "" {
+		push = websub.New(logger.Named("websub"), b.db, websub.Config{
+			CallbackUrl: cfg.WebSubCallbackUrl,
+			Addr:        cfg.WebSubAddr,
+		})
+		ctx.Start(push)
+	}
+
 	b.hackerNews = feeder.New(
 		logger,
 		b.contentPublisher,
@@ -240,6 +426,9 @@ func (b *Telegram) initFeeds(ctx run.Context, cfg TelegramConfig) {
 		rss.New(
 			logger.Named("rss"),
 			b.db,
+			b.contentPublisher,
+			push,
+			cfg.RssSecretKey,
 		),
 	)
 
@@ -250,6 +439,8 @@ func (b *Telegram) initFeeds(ctx run.Context, cfg TelegramConfig) {
 		scrapper.New(
 			logger.Named("scrapper"),
 			b.db,
+			scrapper.WithDefinitionsFile(cfg.ScrapperDefinitions),
+			scrapper.WithClientConfig(cfg.ScrapperClient),
 		),
 	)
 
@@ -3,10 +3,15 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/camopy/rss_everything/bot"
+	"github.com/camopy/rss_everything/bot/feeder/rss"
+	"github.com/camopy/rss_everything/bot/feeder/scrapper"
 	"github.com/camopy/rss_everything/db"
 	. "github.com/camopy/rss_everything/util/run"
 	"github.com/camopy/rss_everything/zaplog"
@@ -21,6 +26,14 @@ type Config struct {
 	RedditApiKey   string
 	RedditUsername string
 	RedditPassword string
+
+	WebSubCallbackUrl string
+	WebSubAddr        string
+
+	RssSecretKey string
+	// ScrapperDefinitions is the path of a JSON file with scraper definitions.
+	ScrapperDefinitions string
+	ScrapperClient      scrapper.ClientConfig
 }
 
 func main() {
@@ -48,6 +61,13 @@ func main() {
 				RedditApiKey:   cfg.RedditApiKey,
 				RedditUsername: cfg.RedditUsername,
 				RedditPassword: cfg.RedditPassword,
+
+				WebSubCallbackUrl: cfg.WebSubCallbackUrl,
+				WebSubAddr:        cfg.WebSubAddr,
+
+				RssSecretKey:        cfg.RssSecretKey,
+				ScrapperDefinitions: cfg.ScrapperDefinitions,
+				ScrapperClient:      cfg.ScrapperClient,
 			},
 		)
 		ctx.Start(discordBot)
@@ -62,6 +82,13 @@ func main() {
 				RedditApiKey:   cfg.RedditApiKey,
 				RedditUsername: cfg.RedditUsername,
 				RedditPassword: cfg.RedditPassword,
+
+				WebSubCallbackUrl: cfg.WebSubCallbackUrl,
+				WebSubAddr:        cfg.WebSubAddr,
+
+				RssSecretKey:        cfg.RssSecretKey,
+				ScrapperDefinitions: cfg.ScrapperDefinitions,
+				ScrapperClient:      cfg.ScrapperClient,
 			},
 		)
 		ctx.Start(telegramBot)
@@ -98,33 +125,75 @@ func decodeEnv() (*Config, error) {
 	}
 	cfg.DiscordApiKey = discordApiKey
 
-	redditClientId, err := lookupEnv("REDDIT_CLIENT_ID")
-	if err != nil {
-		return nil, err
+	// Reddit credentials are optional, the public listings are read without
+	// them.
+	cfg.RedditClientId = os.Getenv("REDDIT_CLIENT_ID")
+	cfg.RedditApiKey = os.Getenv("REDDIT_API_KEY")
+	cfg.RedditUsername = os.Getenv("REDDIT_USERNAME")
+	cfg.RedditPassword = os.Getenv("REDDIT_PASSWORD")
+
+	// WebSub push is optional, RSS feeds are only polled without it.
+	cfg.WebSubCallbackUrl = os.Getenv("WEBSUB_CALLBACK_URL")
+	cfg.WebSubAddr = lookupEnvOrDefault("WEBSUB_ADDR", ":9092")
+	cfg.RssSecretKey = os.Getenv("RSS_SECRET_KEY")
+	if cfg.RssSecretKey != "" {
+		if err := rss.ValidateSecretKey(cfg.RssSecretKey); err != nil {
+			return nil, fmt.Errorf("invalid RSS_SECRET_KEY: %w", err)
+		}
 	}
-	cfg.RedditClientId = redditClientId
-
-	redditApiKey, err := lookupEnv("REDDIT_API_KEY")
+	cfg.ScrapperDefinitions = os.Getenv("SCRAPPER_DEFINITIONS")
+	scrapperClient, err := decodeScrapperClient()
 	if err != nil {
 		return nil, err
 	}
-	cfg.RedditApiKey = redditApiKey
+	cfg.ScrapperClient = scrapperClient
 
-	redditUsername, err := lookupEnv("REDDIT_USERNAME")
-	if err != nil {
-		return nil, err
-	}
-	cfg.RedditUsername = redditUsername
+	return cfg, nil
+}
 
-	redditPassword, err := lookupEnv("REDDIT_PASSWORD")
-	if err != nil {
-		return nil, err
+// decodeScrapperClient reads the optional scrapper request settings, the
+// proxies are a comma separated list of urls.
+func decodeScrapperClient() (scrapper.ClientConfig, error) {
+	var cfg scrapper.ClientConfig
+	if v := os.Getenv("SCRAPPER_PARALLELISM"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid SCRAPPER_PARALLELISM: %w", err)
+		}
+		cfg.Parallelism = n
+	}
+	if v := os.Getenv("SCRAPPER_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid SCRAPPER_DELAY: %w", err)
+		}
+		cfg.Delay = d
+	}
+	if v := os.Getenv("SCRAPPER_RESPECT_ROBOTS_TXT"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid SCRAPPER_RESPECT_ROBOTS_TXT: %w", err)
+		}
+		cfg.RespectRobotsTxt = b
+	}
+	for _, p := range strings.Split(os.Getenv("SCRAPPER_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			cfg.Proxies = append(cfg.Proxies, p)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid SCRAPPER_PROXIES: %w", err)
 	}
-	cfg.RedditPassword = redditPassword
-
 	return cfg, nil
 }
 
+func lookupEnvOrDefault(key string, defaultValue string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return defaultValue
+}
+
 func lookupEnv(key string) (string, error) {
 	v, ok := os.LookupEnv(key)
 	if !ok {
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var errMemoryNotFound = errors.New("memory: key not found")
+
+// Memory is an in-process DB, used by tests and local runs without Redis.
+type Memory struct {
+	mu     sync.Mutex
+	values map[string]memoryValue
+	hashes map[string]map[string]string
+}
+
+type memoryValue struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+func NewMemory() DB {
+	return &Memory{
+		values: make(map[string]memoryValue),
+		hashes: make(map[string]map[string]string),
+	}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.values[key]
+	if !ok || (!v.expiresAt.IsZero() && time.Now().After(v.expiresAt)) {
+		return nil, errMemoryNotFound
+	}
+	return v.data, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v := memoryValue{data: value}
+	if ttl > 0 {
+		v.expiresAt = time.Now().Add(ttl)
+	}
+	m.values[key] = v
+	return nil
+}
+
+func (m *Memory) Add(ctx context.Context, key string, value []byte) (id string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hashes[key] == nil {
+		m.hashes[key] = make(map[string]string)
+	}
+	id = uuid.New().String()
+	m.hashes[key][id] = string(value)
+	return id, nil
+}
+
+func (m *Memory) List(ctx context.Context, key string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make(map[string]string, len(m.hashes[key]))
+	for id, v := range m.hashes[key] {
+		res[id] = v
+	}
+	return res, nil
+}
+
+func (m *Memory) Del(ctx context.Context, key string, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.hashes[key], id)
+	return nil
+}
+
+func (m *Memory) IsErrNotFound(err error) bool {
+	return errors.Is(err, errMemoryNotFound)
+}
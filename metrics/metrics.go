@@ -36,6 +36,13 @@ func TrackExternalRequest(method, endpoint string, statusCode int, duration time
 	metrics.externalRequestTotal.WithLabelValues(method, endpoint, code).Inc()
 }
 
+// TrackFailedExternalRequest records a request that got no response, such as
+// a timeout or a connection error, with the "error" code.
+func TrackFailedExternalRequest(method, endpoint string, duration time.Duration) {
+	metrics.externalRequestDuration.WithLabelValues(method, endpoint, "error").Observe(duration.Seconds())
+	metrics.externalRequestTotal.WithLabelValues(method, endpoint, "error").Inc()
+}
+
 func TrackDuration(observe func(float64)) (stop func()) {
 	start := time.Now()
 	return func() {
@@ -0,0 +1,431 @@
+package websub
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/camopy/rss_everything/db"
+	"github.com/camopy/rss_everything/metrics"
+	"github.com/camopy/rss_everything/util/run"
+	"github.com/camopy/rss_everything/zaplog"
+)
+
+const (
+	defaultLeaseDuration = 10 * 24 * time.Hour
+	// renewBefore is how long before expiry a lease is renewed.
+	renewBefore   = time.Hour
+	renewInterval = 5 * time.Minute
+	// pendingTimeout is how long a subscribe request waits for the hub to
+	// verify it before it is sent again.
+	pendingTimeout = time.Hour
+	httpTimeout    = 30 * time.Second
+	maxBodySize    = 10 << 20
+)
+
+type Config struct {
+	// CallbackUrl is the public base url the hubs deliver to, e.g.
+	// https://bot.example.com/websub. Each topic gets its own path under it.
+	CallbackUrl string
+	// Addr is the address the callback server listens on, e.g. :9092.
+	Addr          string
+	LeaseDuration time.Duration
+}
+
+// Handler is called with the content a hub pushed for a subscription.
+type Handler func(ctx context.Context, id string, body []byte)
+
+// Subscriber subscribes to WebSub hubs, verifies their callbacks and renews
+// the leases before they expire.
+type Subscriber struct {
+	cfg    Config
+	logger *zaplog.Logger
+	db     db.DB
+	http   *http.Client
+
+	mu      sync.Mutex
+	leases  map[string]*lease
+	handler Handler
+	ctx     context.Context //nolint:containedctx // used by push handlers
+}
+
+type lease struct {
+	Id        string    `json:"id"`
+	Hub       string    `json:"hub"`
+	Topic     string    `json:"topic"`
+	Secret    string    `json:"secret"`
+	Verified  bool      `json:"verified"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// RequestedAt is when the pending subscribe request was sent.
+	RequestedAt time.Time `json:"-"`
+}
+
+func New(logger *zaplog.Logger, db db.DB, cfg Config) *Subscriber {
+	if cfg.LeaseDuration == 0 {
+		cfg.LeaseDuration = defaultLeaseDuration
+	}
+	cfg.CallbackUrl = strings.TrimRight(cfg.CallbackUrl, "/")
+	return &Subscriber{
+		cfg:    cfg,
+		logger: logger,
+		db:     db,
+		http:   &http.Client{Timeout: httpTimeout},
+		leases: make(map[string]*lease),
+		ctx:    context.Background(),
+	}
+}
+
+func (s *Subscriber) Name() string {
+	return "websub"
+}
+
+func (s *Subscriber) Start(ctx run.Context) error {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	srv := &http.Server{
+		Addr:              s.cfg.Addr,
+		Handler:           s,
+		ReadHeaderTimeout: httpTimeout,
+	}
+	ctx.Go("callback-server", func(context.Context) error {
+		s.logger.Info("starting websub callback server", zap.String("addr", s.cfg.Addr), zap.String("url", s.cfg.CallbackUrl))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	})
+	ctx.OnCancel(func() {
+		_ = srv.Close()
+	})
+	ctx.Go("renew-leases", s.renewLeases)
+	return nil
+}
+
+// SetHandler sets the function receiving pushed content.
+func (s *Subscriber) SetHandler(h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = h
+}
+
+// IsActive reports whether the hub verified the subscription and its lease
+// has not expired.
+func (s *Subscriber) IsActive(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.leases[id]
+	return ok && l.Verified && time.Now().Before(l.ExpiresAt)
+}
+
+// Subscribe asks hub to push topic to the callback of id. A stored lease for
+// the same hub and topic that is not about to expire is reused, and no new
+// request is sent while the hub has not verified the last one.
+func (s *Subscriber) Subscribe(ctx context.Context, id, hub, topic string) error {
+	s.mu.Lock()
+	var secret string
+	if l, ok := s.leases[id]; ok && !l.Verified && l.Hub == hub && l.Topic == topic {
+		if time.Since(l.RequestedAt) < pendingTimeout {
+			s.mu.Unlock()
+			return nil
+		}
+		// Keep the secret, the hub may still verify the last request.
+		secret = l.Secret
+	}
+	s.mu.Unlock()
+
+	l, err := s.getLease(ctx, id)
+	if err != nil {
+		return err
+	}
+	if l != nil && l.Hub == hub && l.Topic == topic && time.Until(l.ExpiresAt) > renewBefore {
+		s.mu.Lock()
+		s.leases[id] = l
+		s.mu.Unlock()
+		return nil
+	}
+
+	if secret == "" {
+		if secret, err = newSecret(); err != nil {
+			return err
+		}
+	}
+	l = &lease{
+		Id:          id,
+		Hub:         hub,
+		Topic:       topic,
+		Secret:      secret,
+		RequestedAt: time.Now(),
+	}
+	s.mu.Lock()
+	s.leases[id] = l
+	s.mu.Unlock()
+
+	if err := s.request(ctx, "subscribe", l); err != nil {
+		// Retry on the next call.
+		s.mu.Lock()
+		l.RequestedAt = time.Time{}
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Unsubscribe asks the hub to stop pushing to the callback of id. The stored
+// lease is used when id was not subscribed since the bot started.
+func (s *Subscriber) Unsubscribe(ctx context.Context, id string) error {
+	s.mu.Lock()
+	l, ok := s.leases[id]
+	delete(s.leases, id)
+	s.mu.Unlock()
+	if !ok {
+		var err error
+		if l, err = s.getLease(ctx, id); err != nil || l == nil {
+			return err
+		}
+	}
+	if err := s.deleteLease(ctx, id); err != nil {
+		return err
+	}
+	return s.request(ctx, "unsubscribe", l)
+}
+
+func (s *Subscriber) request(ctx context.Context, mode string, l *lease) error {
+	form := url.Values{
+		"hub.mode":     {mode},
+		"hub.topic":    {l.Topic},
+		"hub.callback": {s.callbackUrl(l.Id)},
+	}
+	if mode == "subscribe" {
+		form.Set("hub.lease_seconds", strconv.Itoa(int(s.cfg.LeaseDuration.Seconds())))
+		form.Set("hub.secret", l.Secret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.Hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	start := time.Now()
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	metrics.TrackExternalRequest(http.MethodPost, resp.Request.URL.Host, resp.StatusCode, time.Since(start))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("websub: %s %s: hub returned status %d: %s", mode, l.Topic, resp.StatusCode, body)
+	}
+	s.logger.Info("websub request accepted", zap.String("mode", mode), zap.String("hub", l.Hub), zap.String("topic", l.Topic))
+	return nil
+}
+
+func (s *Subscriber) callbackUrl(id string) string {
+	return s.cfg.CallbackUrl + "/" + url.PathEscape(id)
+}
+
+func (s *Subscriber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	switch r.Method {
+	case http.MethodGet:
+		s.verify(w, r, id)
+	case http.MethodPost:
+		s.receive(w, r, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// verify answers the intent verification of the hub.
+func (s *Subscriber) verify(w http.ResponseWriter, r *http.Request, id string) {
+	q := r.URL.Query()
+	mode := q.Get("hub.mode")
+
+	s.mu.Lock()
+	l, ok := s.leases[id]
+	s.mu.Unlock()
+
+	switch mode {
+	case "denied":
+		s.logger.Warn("websub subscription denied", zap.String("id", id), zap.String("reason", q.Get("hub.reason")))
+		s.mu.Lock()
+		delete(s.leases, id)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+		return
+	case "subscribe":
+		if !ok || l.Topic != q.Get("hub.topic") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		seconds, err := strconv.Atoi(q.Get("hub.lease_seconds"))
+		if err != nil || seconds <= 0 {
+			seconds = int(s.cfg.LeaseDuration.Seconds())
+		}
+		s.mu.Lock()
+		l.Verified = true
+		l.ExpiresAt = time.Now().Add(time.Duration(seconds) * time.Second)
+		verified := *l
+		s.mu.Unlock()
+		if err := s.saveLease(r.Context(), &verified); err != nil {
+			s.logger.Error("failed to save websub lease", zap.Error(err))
+		}
+		s.logger.Info("websub subscription verified", zap.String("id", id), zap.String("topic", l.Topic), zap.Time("expiresAt", verified.ExpiresAt))
+	case "unsubscribe":
+		// The lease was already removed by Unsubscribe.
+		if ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, q.Get("hub.challenge"))
+}
+
+// receive checks the signature of pushed content and hands it to the handler.
+// Content with a bad signature is acknowledged but dropped, as the spec asks.
+func (s *Subscriber) receive(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	l, ok := s.leases[id]
+	handler := s.handler
+	ctx := s.ctx
+	s.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+
+	if !validSignature(l.Secret, r.Header.Get("X-Hub-Signature"), body) {
+		s.logger.Warn("dropping websub content with invalid signature", zap.String("id", id))
+		return
+	}
+	if handler != nil {
+		go handler(ctx, id, body)
+	}
+}
+
+func validSignature(secret, header string, body []byte) bool {
+	algo, sig, ok := strings.Cut(header, "=")
+	if !ok {
+		return false
+	}
+	var h func() hash.Hash
+	switch algo {
+	case "sha1":
+		h = sha1.New
+	case "sha256":
+		h = sha256.New
+	case "sha384":
+		h = sha512.New384
+	case "sha512":
+		h = sha512.New
+	default:
+		return false
+	}
+	expected, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func (s *Subscriber) renewLeases(ctx context.Context) error {
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			for _, l := range s.expiringLeases() {
+				s.logger.Info("renewing websub lease", zap.String("id", l.Id), zap.String("topic", l.Topic))
+				if err := s.request(ctx, "subscribe", l); err != nil {
+					s.logger.Error("failed to renew websub lease", zap.String("id", l.Id), zap.Error(err))
+				}
+			}
+		}
+	}
+}
+
+func (s *Subscriber) expiringLeases() []*lease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var leases []*lease
+	for _, l := range s.leases {
+		if l.Verified && time.Until(l.ExpiresAt) < renewBefore {
+			leases = append(leases, l)
+		}
+	}
+	return leases
+}
+
+func (s *Subscriber) getLease(ctx context.Context, id string) (*lease, error) {
+	b, err := s.db.Get(ctx, fmt.Sprintf("websub:leases:%s", id))
+	if err != nil {
+		if s.db.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// A deleted lease is null until it expires.
+	var l *lease
+	if err := json.Unmarshal(b, &l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (s *Subscriber) saveLease(ctx context.Context, l *lease) error {
+	value, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return s.db.Set(ctx, fmt.Sprintf("websub:leases:%s", l.Id), value, time.Until(l.ExpiresAt))
+}
+
+func (s *Subscriber) deleteLease(ctx context.Context, id string) error {
+	// Overwrite with an already expired value, DB has no plain delete.
+	return s.db.Set(ctx, fmt.Sprintf("websub:leases:%s", id), []byte("null"), time.Millisecond)
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,171 @@
+package websub
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/camopy/rss_everything/db"
+	"github.com/camopy/rss_everything/zaplog"
+)
+
+// fakeHub accepts subscriptions, verifies the intent synchronously and can
+// publish signed content to the subscribers.
+type fakeHub struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	callback string
+	secret   string
+	verified bool
+}
+
+func (h *fakeHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	assert.NoError(h.t, r.ParseForm())
+	mode := r.PostForm.Get("hub.mode")
+	callback := r.PostForm.Get("hub.callback")
+
+	q := url.Values{
+		"hub.mode":      {mode},
+		"hub.topic":     {r.PostForm.Get("hub.topic")},
+		"hub.challenge": {"challenge-123"},
+	}
+	if mode == "subscribe" {
+		q.Set("hub.lease_seconds", "7200")
+	}
+	resp, err := http.Get(callback + "?" + q.Encode())
+	assert.NoError(h.t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	h.mu.Lock()
+	h.callback = callback
+	h.secret = r.PostForm.Get("hub.secret")
+	h.verified = resp.StatusCode == http.StatusOK && string(body) == "challenge-123"
+	h.mu.Unlock()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *fakeHub) publish(body string, secret string) int {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	req, _ := http.NewRequest(http.MethodPost, h.callback, strings.NewReader(body))
+	req.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(h.t, err)
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestSubscriber(t *testing.T) {
+	hub := &fakeHub{t: t}
+	hubSrv := httptest.NewServer(hub)
+	defer hubSrv.Close()
+
+	s := New(zaplog.L(), db.NewMemory(), Config{})
+	callbackSrv := httptest.NewServer(s)
+	defer callbackSrv.Close()
+	s.cfg.CallbackUrl = callbackSrv.URL + "/websub"
+
+	received := make(chan string, 1)
+	s.SetHandler(func(ctx context.Context, id string, body []byte) {
+		received <- id + ":" + string(body)
+	})
+
+	ctx := context.Background()
+	assert.NoError(t, s.Subscribe(ctx, "sub-1", hubSrv.URL, "https://example.com/feed"))
+	assert.True(t, hub.verified)
+	assert.Equal(t, callbackSrv.URL+"/websub/sub-1", hub.callback)
+	assert.True(t, s.IsActive("sub-1"))
+
+	t.Run("signed content is delivered", func(t *testing.T) {
+		assert.Equal(t, http.StatusAccepted, hub.publish("<feed/>", hub.secret))
+		select {
+		case got := <-received:
+			assert.Equal(t, "sub-1:<feed/>", got)
+		case <-time.After(time.Second):
+			t.Fatal("content not delivered")
+		}
+	})
+
+	t.Run("content with a bad signature is dropped", func(t *testing.T) {
+		assert.Equal(t, http.StatusAccepted, hub.publish("<feed/>", "wrong"))
+		select {
+		case <-received:
+			t.Fatal("content with bad signature delivered")
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("stored lease is reused", func(t *testing.T) {
+		s2 := New(zaplog.L(), s.db, Config{CallbackUrl: s.cfg.CallbackUrl})
+		hub.verified = false
+		assert.NoError(t, s2.Subscribe(ctx, "sub-1", hubSrv.URL, "https://example.com/feed"))
+		assert.False(t, hub.verified)
+		assert.True(t, s2.IsActive("sub-1"))
+	})
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		assert.NoError(t, s.Unsubscribe(ctx, "sub-1"))
+		assert.True(t, hub.verified)
+		assert.False(t, s.IsActive("sub-1"))
+		assert.Equal(t, http.StatusGone, hub.publish("<feed/>", hub.secret))
+	})
+
+	t.Run("unsubscribe after a restart", func(t *testing.T) {
+		assert.NoError(t, s.Subscribe(ctx, "sub-2", hubSrv.URL, "https://example.com/feed"))
+		s2 := New(zaplog.L(), s.db, Config{CallbackUrl: s.cfg.CallbackUrl})
+		assert.NoError(t, s2.Unsubscribe(ctx, "sub-2"))
+		l, err := s2.getLease(ctx, "sub-2")
+		assert.NoError(t, err)
+		assert.Nil(t, l)
+	})
+}
+
+func TestSubscribePending(t *testing.T) {
+	var mu sync.Mutex
+	var secrets []string
+	// The hub accepts the requests but verifies them later.
+	hubSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		mu.Lock()
+		secrets = append(secrets, r.PostForm.Get("hub.secret"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hubSrv.Close()
+
+	s := New(zaplog.L(), db.NewMemory(), Config{CallbackUrl: "https://bot.example/websub"})
+	ctx := context.Background()
+	assert.NoError(t, s.Subscribe(ctx, "sub-1", hubSrv.URL, "https://example.com/feed"))
+	assert.NoError(t, s.Subscribe(ctx, "sub-1", hubSrv.URL, "https://example.com/feed"))
+	assert.Len(t, secrets, 1)
+	assert.False(t, s.IsActive("sub-1"))
+
+	// A request the hub never verified is sent again with the same secret.
+	s.mu.Lock()
+	s.leases["sub-1"].RequestedAt = time.Now().Add(-pendingTimeout)
+	s.mu.Unlock()
+	assert.NoError(t, s.Subscribe(ctx, "sub-1", hubSrv.URL, "https://example.com/feed"))
+	assert.Len(t, secrets, 2)
+	assert.Equal(t, secrets[0], secrets[1])
+}
+
+func TestValidSignature(t *testing.T) {
+	assert.True(t, validSignature("secret", "sha1=1aa349585ed7ecbd3b9c486a30067e395ca4b356", []byte("test")))
+	assert.False(t, validSignature("secret", "sha1=00", []byte("test")))
+	assert.False(t, validSignature("secret", "md5=00", []byte("test")))
+	assert.False(t, validSignature("secret", "", []byte("test")))
+}